@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Commands to copy to and paste from the system clipboard, by backend name.
+// The copy commands fork to keep serving the selection, so their output is
+// discarded to keep them from holding our pipes open
+var clipboardCommands = map[string][2]string{
+	"xclip": {"xclip -selection clipboard >/dev/null 2>&1",
+		"xclip -o -selection clipboard"},
+	"wl-copy": {"wl-copy >/dev/null 2>&1", "wl-paste -n"},
+}
+
+// Clipboard backend set in the configuration file: osc52, xclip, wl-copy, or
+// "" to choose automatically
+var clipboardBackend string
+
+// Returns true if name is a valid clipboard backend
+func isClipboardBackend(name string) bool {
+	_, ok := clipboardCommands[name]
+	return ok || name == "osc52" || name == "auto"
+}
+
+// Return the clipboard backend to use
+func clipboard() string {
+	if clipboardBackend != "" && clipboardBackend != "auto" {
+		return clipboardBackend
+	}
+	if _, err := exec.LookPath("wl-copy"); err == nil &&
+		os.Getenv("WAYLAND_DISPLAY") != "" {
+		return "wl-copy"
+	}
+	if _, err := exec.LookPath("xclip"); err == nil &&
+		os.Getenv("DISPLAY") != "" {
+		return "xclip"
+	}
+	return "osc52"
+}
+
+// Return the OSC 52 escape sequence that sets the clipboard to s. Inside tmux,
+// the sequence is wrapped so that tmux passes it through to the terminal
+func osc52(s string, tmux bool) string {
+	seq := "\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(s)) + "\a"
+	if tmux {
+		seq = "\x1bPtmux;" + strings.Replace(seq, "\x1b", "\x1b\x1b", -1) +
+			"\x1b\\"
+	}
+	return seq
+}
+
+// Copy s to the system clipboard
+func writeClipboard(s string) error {
+	if backend := clipboard(); backend != "osc52" {
+		_, err := runCommand(clipboardCommands[backend][0], s)
+		return err
+	}
+	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer tty.Close()
+	_, err = fmt.Fprint(tty, osc52(s, os.Getenv("TMUX") != ""))
+	return err
+}
+
+// Return the contents of the system clipboard. With OSC 52, which terminals
+// rarely allow reading, this is the last text copied from Zygote
+func readClipboard() (string, error) {
+	if backend := clipboard(); backend != "osc52" {
+		return runCommand(clipboardCommands[backend][1], "")
+	}
+	return register['+'], nil
+}
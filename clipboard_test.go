@@ -0,0 +1,50 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestOSC52(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		tmux bool
+		want string
+	}{
+		{"hi", false, "\x1b]52;c;aGk=\a"},
+		{"hi", true, "\x1bPtmux;\x1b\x1b]52;c;aGk=\a\x1b\\"},
+	} {
+		if got := osc52(c.s, c.tmux); got != c.want {
+			t.Errorf("osc52(%#v, %#v) == %#v; want %#v", c.s, c.tmux, got,
+				c.want)
+		}
+	}
+}
+
+func TestClipboardBackend(t *testing.T) {
+	for _, name := range []string{"osc52", "xclip", "wl-copy", "auto"} {
+		if !isClipboardBackend(name) {
+			t.Errorf("isClipboardBackend(%#v) == false; want true", name)
+		}
+	}
+	if isClipboardBackend("pbcopy") {
+		t.Errorf("isClipboardBackend(\"pbcopy\") == true; want false")
+	}
+
+	// The configured backend's commands are used
+	oldBackend, oldCommands := clipboardBackend, clipboardCommands["xclip"]
+	defer func() {
+		clipboardBackend, clipboardCommands["xclip"] = oldBackend, oldCommands
+	}()
+	path := filepath.Join(t.TempDir(), "clipboard")
+	clipboardCommands["xclip"] = [2]string{"cat >" + path, "cat " + path}
+	if !configDirective("clipboard xclip") || clipboard() != "xclip" {
+		t.Fatalf("clipboard() == %#v; want \"xclip\"", clipboard())
+	}
+	if err := writeClipboard("copied"); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := readClipboard(); err != nil || s != "copied" {
+		t.Errorf("readClipboard() == %#v, %v; want \"copied\", nil", s, err)
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A named action that keys can be bound to
+type command struct {
+	name, desc string
+	mode       bool // Whether the command toggles a mode
+}
+
+// Commands run by runKey
+var commands = []command{
+	{"append-register", "Type onto end of register", false},
+	{"append-yank", "Yank onto end of register", false},
+	{"arithmetic", "Add to, subtract from, multiply, or divide register",
+		false},
+	{"cancel", "Cancel prompt, search, or command", false},
+	{"char-left", "Previous character", false},
+	{"char-right", "Next character", false},
+	{"command", "Run command by name", false},
+	{"delete-char", "Delete character", false},
+	{"delete-forward", "Delete next character", false},
+	{"delete-line", "Delete line", false},
+	{"delete-word", "Delete word", false},
+	{"diff", "Diff buffer against file or buffer", false},
+	{"execute", "Execute from register", false},
+	{"git-mode", "Git (show changes since last commit)", true},
+	{"grep", "Search files under directory", false},
+	{"history", "Show branches of undo history", false},
+	{"insert-output", "Insert output of command", false},
+	{"jump-back", "Jump back from tag", false},
+	{"jump-to-tag", "Jump to tag (definition) under cursor", false},
+	{"line-down", "Next line, or scroll down in view mode", false},
+	{"line-end", "End of line", false},
+	{"line-start", "Start of line", false},
+	{"line-up", "Previous line, or scroll up in view mode", false},
+	{"lsp-complete", "Complete symbol (LSP)", false},
+	{"lsp-definition", "Jump to definition (LSP)", false},
+	{"lsp-hover", "Show information about symbol (LSP)", false},
+	{"lsp-mode", "LSP (language server)", true},
+	{"lsp-rename", "Rename symbol (LSP)", false},
+	{"make", "Make (run build command)", false},
+	{"manual-mode", "Manual", true},
+	{"newline", "Insert line break, or confirm prompt", false},
+	{"next-buffer", "Next buffer", false},
+	{"next-error", "Next build error", false},
+	{"next-hunk", "Next change (git)", false},
+	{"open", "Open file", false},
+	{"page-down", "Next page", false},
+	{"page-up", "Previous page", false},
+	{"pipe", "Pipe selection or buffer through command", false},
+	{"previous-error", "Previous build error", false},
+	{"previous-hunk", "Previous change (git)", false},
+	{"put", "Put from register", false},
+	{"put-previous", "Replace just-put text with previous deletion", false},
+	{"quit", "Quit", false},
+	{"read-only-mode", "Read-only (current buffer)", true},
+	{"redo", "Redo change to buffer or prompt", false},
+	{"registers", "Show registers", false},
+	{"revert-hunk", "Revert change at cursor (git)", false},
+	{"run", "Run command in output buffer", false},
+	{"save", "Save file", false},
+	{"search-backward", "Backward search", false},
+	{"search-forward", "Forward search", false},
+	{"select-mode", "Select", true},
+	{"space", "Insert space", false},
+	{"suspend", "Suspend process", false},
+	{"tab", "Insert tab", false},
+	{"travel", "Go to earlier or later state of buffer", false},
+	{"type-register", "Type into register", false},
+	{"undo", "Undo change to buffer or prompt", false},
+	{"view-mode", "View", true},
+	{"word-mode", "Word", true},
+	{"yank", "Yank into register", false},
+}
+
+var (
+	pendingKeys string                    // Incomplete key sequence
+	keyTimeout  = 1000 * time.Millisecond // To wait for the next key
+	keyTimer    *time.Timer               // Timeout of the pending sequence
+
+	// Number of bound sequences each key sequence begins, kept by bindKey and
+	// unbindKey. The default bindings are all single keys
+	keyPrefixes = make(map[string]int)
+)
+
+// Key bindings, from key forms or sequences of them to command names or
+// macros
+var bindings = map[string]string{
+	"<Backspace>": "delete-char",
+	"<Delete>":    "delete-forward",
+	"<Down>":      "line-down",
+	"<End>":       "line-end",
+	"<Enter>":     "newline",
+	"<Home>":      "line-start",
+	"<Left>":      "char-left",
+	"<PgDn>":      "page-down",
+	"<PgUp>":      "page-up",
+	"<Right>":     "char-right",
+	"<Space>":     "space",
+	"<Tab>":       "tab",
+	"<Up>":        "line-up",
+	"<C-6>":       "jump-back",
+	"<C-8>":       "delete-char",
+	"<C-\\>":      "pipe",
+	"<C-]>":       "jump-to-tag",
+	"<C-_>":       "undo",
+	"<C-/>":       "undo",
+	"<C-a>":       "line-start",
+	"<C-b>":       "search-backward",
+	"<C-c>":       "cancel",
+	"<C-d>":       "diff",
+	"<C-e>":       "line-end",
+	"<C-f>":       "search-forward",
+	"<C-g>":       "insert-output",
+	"<C-h>":       "delete-char",
+	"<C-i>":       "tab",
+	"<C-k>":       "make",
+	"<C-l>":       "grep",
+	"<C-m>":       "newline",
+	"<C-n>":       "next-buffer",
+	"<C-o>":       "open",
+	"<C-p>":       "put",
+	"<C-q>":       "quit",
+	"<C-r>":       "redo",
+	"<C-s>":       "save",
+	"<C-t>":       "type-register",
+	"<C-u>":       "delete-line",
+	"<C-v>":       "run",
+	"<C-w>":       "delete-word",
+	"<C-x>":       "execute",
+	"<C-y>":       "yank",
+	"<C-z>":       "suspend",
+	"<F1>":        "lsp-hover",
+	"<F2>":        "lsp-rename",
+	"<F3>":        "lsp-complete",
+	"<F4>":        "revert-hunk",
+	"<F5>":        "previous-hunk",
+	"<F6>":        "next-hunk",
+	"<F7>":        "previous-error",
+	"<F8>":        "next-error",
+	"<F9>":        "travel",
+	"<F10>":       "history",
+	"<F11>":       "registers",
+	"<F12>":       "lsp-definition",
+	"<M-a>":       "arithmetic",
+	"<M-g>":       "git-mode",
+	"<M-l>":       "lsp-mode",
+	"<M-m>":       "manual-mode",
+	"<M-p>":       "put-previous",
+	"<M-r>":       "read-only-mode",
+	"<M-s>":       "select-mode",
+	"<M-t>":       "append-register",
+	"<M-v>":       "view-mode",
+	"<M-w>":       "word-mode",
+	"<M-x>":       "command",
+	"<M-y>":       "append-yank",
+}
+
+// Return the command with the given name
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// Returns true if s is a single key: a key form like <C-w>, or one character
+func isKeyForm(s string) bool {
+	return formRegexp.FindString(s) == s || len(s) == 1
+}
+
+// Returns true if s is a key or a sequence of keys, as handleKey receives
+// them. Escapes and control forms are not keys
+func isKeySequence(s string) bool {
+	keys := macroTokens(s)
+	if len(keys) == 0 || strings.Join(keys, "") != s {
+		return false
+	}
+	for _, k := range keys {
+		if !isKeyForm(k) || controlRegexp.MatchString(k) {
+			return false
+		}
+	}
+	return true
+}
+
+// Bind the key or key sequence to a command, or to a macro if action is not a
+// command name
+func bindKey(key, action string) {
+	if !isKeySequence(key) {
+		msgError("Invalid key: " + key)
+		return
+	}
+	if _, ok := bindings[key]; !ok {
+		countPrefixes(key, 1)
+	}
+	bindings[key] = action
+}
+
+// Remove the key's binding
+func unbindKey(key string) {
+	if _, ok := bindings[key]; !ok {
+		msgError("Unbound key: " + key)
+		return
+	}
+	countPrefixes(key, -1)
+	delete(bindings, key)
+}
+
+// Add n to the counts in keyPrefixes of the sequences the key sequence begins
+// with
+func countPrefixes(key string, n int) {
+	keys := macroTokens(key)
+	for i := 1; i < len(keys); i++ {
+		prefix := strings.Join(keys[:i], "")
+		if keyPrefixes[prefix] += n; keyPrefixes[prefix] <= 0 {
+			delete(keyPrefixes, prefix)
+		}
+	}
+}
+
+// Return the key or key sequence as written in the manual, without angle
+// brackets
+func keyName(key string) string {
+	keys := macroTokens(key)
+	for i, k := range keys {
+		if len(k) > 2 {
+			keys[i] = k[1 : len(k)-1]
+		}
+	}
+	return strings.Join(keys, " ")
+}
+
+// Returns true if the key sequence consists of characters, which type
+// themselves unless bound
+func isTextKeys(seq string) bool {
+	for _, k := range macroTokens(seq) {
+		if len(k) > 1 {
+			return false
+		}
+	}
+	return seq != ""
+}
+
+// Wait for the key that continues the key sequence. If none comes before
+// the timeout, resolve the sequence as it is
+func waitForKey(seq string) {
+	clearPendingKeys()
+	pendingKeys = seq
+	var timer *time.Timer
+	timer = time.AfterFunc(keyTimeout, func() {
+		funcChan <- func() {
+			if keyTimer == timer {
+				resolveKeys(seq)
+			}
+		}
+	})
+	keyTimer = timer
+}
+
+// Forget the pending key sequence, stopping its timeout. A timeout that has
+// already fired is ignored, since keyTimer no longer refers to it
+func clearPendingKeys() {
+	pendingKeys = ""
+	if keyTimer != nil {
+		keyTimer.Stop()
+		keyTimer = nil
+	}
+}
+
+// End the pending key sequence without another key, running the sequence's
+// own binding if it has one, or typing it if it is text. Returns true if the
+// event loop should stop
+func resolveKeys(seq string) bool {
+	clearPendingKeys()
+	if _, ok := bindings[seq]; ok {
+		return runKey(seq)
+	}
+	stop := false
+	if isTextKeys(seq) {
+		for _, ch := range seq {
+			stop = runKey(string(ch)) || stop
+		}
+	}
+	return stop
+}
+
+// Return the order in which a key is listed in the manual: Ctrl chords,
+// function keys, Alt chords, then others
+func keyGroup(key string) int {
+	for i, prefix := range []string{"<C-", "<F", "<M-"} {
+		if strings.HasPrefix(key, prefix) {
+			return i
+		}
+	}
+	return 3
+}
+
+// Return the manual's list of bound keys and their commands, followed by any
+// unbound commands, either of commands or of modes
+func bindingList(modes bool) string {
+	keys := make([]string, 0)
+	width := 0
+	for key, action := range bindings {
+		c, ok := findCommand(action)
+		if ok && c.mode == modes || !ok && !modes {
+			keys = append(keys, key)
+			if n := len(keyName(key)); n > width {
+				width = n
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		gi, gj := keyGroup(keys[i]), keyGroup(keys[j])
+		if gi != gj {
+			return gi < gj
+		}
+		if len(keys[i]) != len(keys[j]) && gi == 1 {
+			return len(keys[i]) < len(keys[j]) // F2 before F10
+		}
+		return keys[i] < keys[j]
+	})
+
+	lines := make([]string, 0, len(keys))
+	bound := make(map[string]bool)
+	for _, key := range keys {
+		desc := "Macro: " + bindings[key]
+		if c, ok := findCommand(bindings[key]); ok {
+			desc = fmt.Sprintf("%s (%s)", c.desc, c.name)
+			bound[c.name] = true
+		}
+		lines = append(lines, fmt.Sprintf("  %-*s  %s", width, keyName(key),
+			desc))
+	}
+	for _, c := range commands {
+		if c.mode == modes && !bound[c.name] {
+			lines = append(lines, fmt.Sprintf("  %-*s  %s (%s)", width, "-",
+				c.desc, c.name))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Return the manual with the lists of key bindings filled in
+func formatManual() string {
+	s := strings.Replace(manualString, "$COMMANDS", bindingList(false), 1)
+	return strings.Replace(s, "$MODES", bindingList(true), 1)
+}
+
+// Return how well the pattern matches the name, and whether it matches at
+// all: the name must contain the pattern's characters in order. Matches that
+// are contiguous or at the start of words score higher, as do shorter names
+func fuzzyScore(pattern, name string) (int, bool) {
+	score, j, prev := 0, 0, -2
+	for i := 0; i < len(name) && j < len(pattern); i++ {
+		if name[i] != pattern[j] {
+			continue
+		}
+		score++
+		if i == prev+1 {
+			score += 2
+		}
+		if i == 0 || name[i-1] == '-' {
+			score += 3
+		}
+		prev = i
+		j++
+	}
+	return score*4 - len(name), j == len(pattern)
+}
+
+// Return the names of commands matching the pattern, best first
+func commandMatches(pattern string) []string {
+	scores := make(map[string]int)
+	names := make([]string, 0)
+	for _, c := range commands {
+		if score, ok := fuzzyScore(pattern, c.name); ok {
+			scores[c.name] = score
+			names = append(names, c.name)
+		}
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		if scores[names[i]] != scores[names[j]] {
+			return scores[names[i]] > scores[names[j]]
+		}
+		return len(names[i]) < len(names[j])
+	})
+	return names
+}
+
+// Return the best few commands matching the pattern, for display after the
+// command prompt
+func commandMatchString(pattern string) string {
+	names := commandMatches(pattern)
+	if len(names) == 0 {
+		return "(no match)"
+	}
+	if len(names) > 5 {
+		names = append(names[:5], "...")
+	}
+	if c, ok := findCommand(names[0]); ok && len(names) == 1 {
+		return names[0] + ": " + c.desc
+	}
+	return "{" + strings.Join(names, " | ") + "}"
+}
+
+// Replace the command prompt's text with the best matching command name
+func completeCommand() {
+	if names := commandMatches(promptText.Get("1.0", "end")); len(names) > 0 {
+		deleteText(promptText, "1.0", "end")
+		insertText(promptText, "1.0", names[0])
+		promptText.MarkSet(cursorMark, "end")
+	}
+}
+
+// Run the command with the given name, or the best match for it
+func runNamedCommand(name string) {
+	if _, ok := findCommand(name); !ok {
+		names := commandMatches(name)
+		if len(names) == 0 {
+			msgError("No such command: " + name)
+			return
+		}
+		name = names[0]
+	}
+	handleKey("<" + name + ">")
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBindings(t *testing.T) {
+	for key, name := range bindings {
+		if _, ok := findCommand(name); !ok {
+			t.Errorf("bindings[%#v] == %#v; not a command", key, name)
+		}
+	}
+
+	withTestBuffer(t)
+	defer func() {
+		bindings["<C-_>"], bindings["<F9>"] = "undo", "travel"
+	}()
+
+	configDirective("bind <F9> ab<Enter>")
+	configDirective("unbind <C-_>")
+	handleKey("<F9>")
+	handleKey("<C-_>")
+	if got, want := mainText.Get("1.0", "end"), "ab\n"; got != want {
+		t.Errorf("text == %#v; want %#v", got, want)
+	}
+	if want := "Unbound key: <C-_>"; statusMsg != want {
+		t.Errorf("statusMsg == %#v; want %#v", statusMsg, want)
+	}
+	want := "F9         Macro: ab<Enter>"
+	if s := formatManual(); !strings.Contains(s, want) {
+		t.Errorf("formatManual() does not list macro binding")
+	}
+}
+
+func TestIsKeyForm(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		want bool
+	}{
+		{"<C-w>", true},
+		{"x", true},
+		{"<", true},
+		{"xy", false},
+		{"<C-w>x", false},
+	} {
+		if got := isKeyForm(c.s); got != c.want {
+			t.Errorf("isKeyForm(%#v) == %#v; want %#v", c.s, got, c.want)
+		}
+	}
+}
+
+func TestIsKeySequence(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		want bool
+	}{
+		{"<C-w>", true},
+		{"<C-k>b", true},
+		{"", false},
+		{"\\<C-w>", false},
+		{"<repeat 2>", false},
+		{"<C-k><done>", false},
+	} {
+		if got := isKeySequence(c.s); got != c.want {
+			t.Errorf("isKeySequence(%#v) == %#v; want %#v", c.s, got, c.want)
+		}
+	}
+}
+
+func TestCommandMatches(t *testing.T) {
+	for _, c := range []struct {
+		pattern, want string
+	}{
+		{"undo", "undo"},
+		{"rdo", "redo"},
+		{"sf", "search-forward"},
+		{"lspdef", "lsp-definition"},
+		{"zzz", ""},
+		{"", "put"}, // Shortest name first
+	} {
+		got := ""
+		if names := commandMatches(c.pattern); len(names) > 0 {
+			got = names[0]
+		}
+		if got != c.want {
+			t.Errorf("commandMatches(%#v)[0] == %#v; want %#v", c.pattern, got,
+				c.want)
+		}
+	}
+}
+
+func TestKeySequences(t *testing.T) {
+	withTestBuffer(t)
+	oldTimeout := keyTimeout
+	defer func() {
+		keyTimeout = oldTimeout
+		bindings["<F9>"] = "travel"
+		unbindKey("<F9>b")
+		unbindKey("jk")
+	}()
+
+	bindKey("<F9>", "a")
+	bindKey("<F9>b", "c")
+	for _, key := range []string{"<F9>", "b", "<F9>", "<C-c>", "<F9>", "x"} {
+		handleKey(key)
+	}
+	if got, want := mainText.Get("1.0", "end"), "c"; got != want {
+		t.Errorf("text == %#v; want %#v", got, want)
+	}
+	if want := "Unbound key: <F9>x"; statusMsg != want {
+		t.Errorf("statusMsg == %#v; want %#v", statusMsg, want)
+	}
+
+	// The prefix's own binding runs after the timeout
+	keyTimeout = time.Millisecond
+	handleKey("<F9>")
+	select {
+	case f := <-funcChan:
+		f()
+	case <-time.After(time.Second):
+		t.Fatal("timeout not reached")
+	}
+	if got, want := mainText.Get("1.0", "end"), "ca"; got != want {
+		t.Errorf("after timeout, text == %#v; want %#v", got, want)
+	}
+
+	// A printable prefix types itself if the sequence goes no further
+	bindKey("jk", "K")
+	for _, key := range []string{"j", "k", "j", "x"} {
+		handleKey(key)
+	}
+	if got, want := mainText.Get("1.0", "end"), "caKjx"; got != want {
+		t.Errorf("after jkjx, text == %#v; want %#v", got, want)
+	}
+
+	// Macros don't wait for the next key
+	execString("<F9>b<F9>")
+	if got, want := mainText.Get("1.0", "end"), "caKjxca"; got != want {
+		t.Errorf("after macro, text == %#v; want %#v", got, want)
+	}
+	if pendingKeys != "" {
+		t.Errorf("after macro, pendingKeys == %#v; want \"\"", pendingKeys)
+	}
+
+	// The timeout of a cancelled sequence doesn't end a new one
+	handleKey("<F9>")
+	time.Sleep(10 * keyTimeout) // The first timeout fires
+	handleKey("<C-c>")
+	keyTimeout = time.Hour
+	handleKey("<F9>")
+	select {
+	case f := <-funcChan:
+		f()
+	case <-time.After(time.Second):
+		t.Fatal("timeout not reached")
+	}
+	if pendingKeys != "<F9>" {
+		t.Errorf("after stale timeout, pendingKeys == %#v; want \"<F9>\"",
+			pendingKeys)
+	}
+	handleKey("<C-c>")
+	if want := "C-k b"; keyName("<C-k>b") != want {
+		t.Errorf("keyName(\"<C-k>b\") == %#v; want %#v", keyName("<C-k>b"),
+			want)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	formatters    = make(map[string]string)   // Keyed by file extension
+	postSaveHooks = make(map[string][]string) // Keyed by file extension
+
+	// Number of arguments each directive takes. The last takes the rest of
+	// the line
+	directiveArgs = map[string]int{
+		"bind":        2,
+		"clipboard":   1,
+		"dateformat":  1,
+		"format":      2,
+		"keytimeout":  1,
+		"noundofile":  1,
+		"postsave":    2,
+		"sessiononly": 1,
+		"unbind":      1,
+	}
+)
+
+// Split the line into its first n-1 words and the remainder of the line.
+// Returns nil if there are fewer than n parts
+func splitWords(line string, n int) []string {
+	parts := make([]string, 0, n)
+	for len(parts) < n-1 {
+		line = strings.TrimLeft(line, " \t")
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			return nil
+		}
+		parts = append(parts, line[:i])
+		line = line[i:]
+	}
+	if line = strings.TrimSpace(line); line == "" {
+		return nil
+	}
+	return append(parts, line)
+}
+
+// Interpret a configuration directive. Returns false if the line is not a
+// directive and should be executed as key input
+func configDirective(line string) bool {
+	words := strings.Fields(line)
+	if len(words) == 0 {
+		return false
+	}
+	n, ok := directiveArgs[words[0]]
+	if !ok {
+		return false
+	}
+	args := splitWords(line, n+1)
+	if args == nil {
+		msgError(words[0] + ": missing argument")
+		return true
+	}
+	switch args[0] {
+	case "format":
+		formatters[args[1]] = args[2]
+	case "postsave":
+		postSaveHooks[args[1]] = append(postSaveHooks[args[1]], args[2])
+	case "noundofile":
+		dir, err := filepath.Abs(expandPath(args[1]))
+		if err != nil {
+			msgError(err.Error())
+			break
+		}
+		noUndoFileIn = append(noUndoFileIn, dir)
+	case "clipboard":
+		if !isClipboardBackend(args[1]) {
+			msgError("Unknown clipboard backend: " + args[1])
+			break
+		}
+		clipboardBackend = args[1]
+	case "bind":
+		bindKey(args[1], args[2])
+	case "unbind":
+		unbindKey(args[1])
+	case "keytimeout":
+		ms, err := strconv.Atoi(args[1])
+		if err != nil || ms <= 0 {
+			msgError("Invalid timeout: " + args[1])
+			break
+		}
+		keyTimeout = time.Duration(ms) * time.Millisecond
+	case "dateformat":
+		dateFormat = args[1]
+	case "sessiononly":
+		for _, ch := range args[1] {
+			sessionRegisters[ch] = true
+		}
+	}
+	return true
+}
+
+// Read the configuration file, interpreting each line as a directive or as key
+// input
+func readConfig(path string) {
+	path = expandPath(path)
+	if p, err := ioutil.ReadFile(path); err == nil {
+		for _, line := range strings.Split(string(p), "\n") {
+			if !configDirective(line) {
+				execString(line)
+			}
+		}
+	} else {
+		msgError(err.Error())
+	}
+}
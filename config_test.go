@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		line string
+		n    int
+		want []string
+	}{
+		{"format .go gofmt -s", 3, []string{"format", ".go", "gofmt -s"}},
+		{"  postsave\t.c  make test ", 3, []string{"postsave", ".c", "make test"}},
+		{"format .go", 3, nil},
+		{"", 1, nil},
+	}
+	for _, test := range tests {
+		if got := splitWords(test.line, test.n); !reflect.DeepEqual(got,
+			test.want) {
+			t.Errorf("splitWords(%#v, %d) == %#v; want %#v", test.line,
+				test.n, got, test.want)
+		}
+	}
+}
+
+func TestConfigDirective(t *testing.T) {
+	if !configDirective("format .go gofmt") || formatters[".go"] != "gofmt" {
+		t.Errorf("format directive not applied")
+	}
+	configDirective("postsave .go go vet")
+	configDirective("postsave .go go test")
+	want := []string{"go vet", "go test"}
+	if got := postSaveHooks[".go"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("postSaveHooks[\".go\"] == %#v; want %#v", got, want)
+	}
+	if !configDirective("sessiononly DO") || !sessionRegisters['D'] {
+		t.Errorf("sessiononly directive not applied")
+	}
+	if configDirective("hello world again") {
+		t.Errorf("configDirective() accepted plain text")
+	}
+	for _, line := range []string{"format", "postsave .go", "bind <F5>",
+		"keytimeout"} {
+		statusMsg = ""
+		word := strings.Fields(line)[0]
+		if !configDirective(line) || statusMsg != word+": missing argument" {
+			t.Errorf("configDirective(%#v): status == %#v; want missing "+
+				"argument", line, statusMsg)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/jangler/tktext"
+	"github.com/nsf/termbox-go"
+)
+
+// A run of differing lines between two texts. Line numbers are 1-based; a hunk
+// with no new lines is a deletion before line newStart
+type hunk struct {
+	oldStart, oldLines, newStart, newLines int
+}
+
+// Return the hunks that turn lines a into lines b, using Myers' algorithm
+func diffLines(a, b []string) []hunk {
+	// Trim the common prefix and suffix, which is usually most of the text
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre &&
+		a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	a, b = a[pre:len(a)-suf], b[pre:len(b)-suf]
+
+	// Find the shortest edit, keeping the endpoints each round starts from
+	// for backtracking. Round d only reads diagonals -d-1 through d+1
+	n, m := len(a), len(b)
+	off := n + m + 1
+	v := make([]int, 2*off+1)
+	trace := make([][]int, 0)
+search:
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, append([]int(nil), v[off-d-1:off+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Backtrack to find matching line pairs, last first
+	matches := make([][2]int, 0)
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v, off := trace[d], d+1
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[off+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			matches = append(matches, [2]int{x, y})
+		}
+		x, y = prevX, prevY
+	}
+
+	// Collect the gaps between matches into hunks
+	hunks := make([]hunk, 0)
+	i, j := 0, 0
+	for k := len(matches) - 1; k >= -1; k-- {
+		mi, mj := n, m
+		if k >= 0 {
+			mi, mj = matches[k][0], matches[k][1]
+		}
+		if mi > i || mj > j {
+			hunks = append(hunks,
+				hunk{pre + i + 1, mi - i, pre + j + 1, mj - j})
+		}
+		i, j = mi+1, mj+1
+	}
+	return hunks
+}
+
+// Return the line of the new text corresponding to line n of the old text,
+// given the hunks between them. A line within a hunk keeps its offset into
+// the hunk, up to the hunk's last new line
+func mapLine(hunks []hunk, n int) int {
+	shift := 0
+	for _, h := range hunks {
+		if n < h.oldStart {
+			break
+		}
+		if n < h.oldStart+h.oldLines {
+			off := n - h.oldStart
+			if off >= h.newLines {
+				off = h.newLines - 1
+			}
+			if off < 0 {
+				off = 0
+			}
+			return h.newStart + off
+		}
+		shift = h.newStart + h.newLines - h.oldStart - h.oldLines
+	}
+	return n + shift
+}
+
+// Replace count lines of the buffer, starting at line start, with the given
+// lines, recording the change for undo. A count of zero inserts the lines
+// before line start
+func replaceLines(t *tktext.TkText, start, count int, lines []string) {
+	last := t.Index("end").Line
+	s := strings.Join(lines, "\n")
+	switch {
+	case count > 0 && len(lines) > 0:
+		deleteText(t, fmt.Sprintf("%d.0", start),
+			fmt.Sprintf("%d.0 lineend", start+count-1))
+		insertText(t, fmt.Sprintf("%d.0", start), s)
+	case count > 0 && start+count <= last:
+		deleteText(t, fmt.Sprintf("%d.0", start),
+			fmt.Sprintf("%d.0", start+count))
+	case count > 0:
+		// Lines at the end of the buffer take the newline before them
+		deleteText(t, fmt.Sprintf("%d.0 lineend", start-1), "end")
+	case start <= last:
+		insertText(t, fmt.Sprintf("%d.0", start), s+"\n")
+	default:
+		insertText(t, "end", "\n"+s)
+	}
+}
+
+// Lines of context around hunks in unified diffs
+const diffContext = 3
+
+// Return a unified diff of lines a and b, and for each line of the diff, the
+// corresponding line number in b
+func unifiedDiff(nameA, nameB string, a, b []string,
+	hunks []hunk) ([]string, []int) {
+	lines := []string{"--- " + nameA, "+++ " + nameB}
+	targets := []int{1, 1}
+
+	for len(hunks) > 0 {
+		// Group hunks whose context would overlap
+		n := 1
+		for n < len(hunks) && hunks[n].oldStart-
+			(hunks[n-1].oldStart+hunks[n-1].oldLines) <= 2*diffContext {
+			n++
+		}
+		group := hunks[:n]
+		hunks = hunks[n:]
+
+		first, last := group[0], group[len(group)-1]
+		oldFrom := first.oldStart - diffContext
+		if oldFrom < 1 {
+			oldFrom = 1
+		}
+		newFrom := first.newStart - (first.oldStart - oldFrom)
+		oldTo := last.oldStart + last.oldLines - 1 + diffContext
+		if oldTo > len(a) {
+			oldTo = len(a)
+		}
+		newTo := last.newStart + last.newLines - 1 +
+			(oldTo - (last.oldStart + last.oldLines - 1))
+		lines = append(lines, fmt.Sprintf("@@ -%s +%s @@",
+			diffRange(oldFrom, oldTo-oldFrom+1),
+			diffRange(newFrom, newTo-newFrom+1)))
+		targets = append(targets, newFrom)
+
+		i, j := oldFrom, newFrom
+		for _, h := range group {
+			for ; i < h.oldStart; i, j = i+1, j+1 {
+				lines = append(lines, " "+a[i-1])
+				targets = append(targets, j)
+			}
+			for k := 0; k < h.oldLines; k++ {
+				lines = append(lines, "-"+a[i-1+k])
+				targets = append(targets, j)
+			}
+			for k := 0; k < h.newLines; k++ {
+				lines = append(lines, "+"+b[j-1+k])
+				targets = append(targets, j+k)
+			}
+			i, j = i+h.oldLines, j+h.newLines
+		}
+		for ; i <= oldTo; i, j = i+1, j+1 {
+			lines = append(lines, " "+a[i-1])
+			targets = append(targets, j)
+		}
+	}
+	return lines, targets
+}
+
+// Format a line range for a unified diff hunk header
+func diffRange(start, count int) string {
+	if count == 0 {
+		start-- // Empty ranges refer to the line before
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+var (
+	diffText    *tktext.TkText // Not initialized unless we need it
+	diffSource  *tktext.TkText // Buffer the diff was made from
+	diffTargets []int          // Lines in diffSource, by line of diffText
+)
+
+// Show the differences between the file or other open buffer at the path and
+// the main buffer
+func diffBuffer(path string) {
+	if focusText != mainText || mainText == diffText {
+		return
+	}
+	var old string
+	if t := findBuffer(path); t != nil && t != mainText {
+		old = string(bufferBytes(t))
+	} else if p, err := ioutil.ReadFile(expandPath(path)); err == nil {
+		old = string(p)
+	} else {
+		msgError(err.Error())
+		return
+	}
+
+	name := filename
+	if name == "" {
+		name = "(unnamed)"
+	}
+	a := strings.Split(old, "\n")
+	b := strings.Split(string(bufferBytes(mainText)), "\n")
+	hunks := diffLines(a, b)
+	if len(hunks) == 0 {
+		msgNormal("No differences.")
+		return
+	}
+	lines, targets := unifiedDiff(path, name, a, b, hunks)
+
+	diffSource, diffTargets = mainText, targets
+	if diffText == nil {
+		diffText = newOutputBuffer()
+	} else {
+		switchBuffer(diffText)
+	}
+	setOutput(diffText, strings.Join(lines, "\n"))
+	diffText.MarkSet(cursorMark, "1.0")
+	msgNormal(fmt.Sprintf("%d changes.", len(hunks)))
+}
+
+// Visit the line of the source buffer corresponding to the cursor line of the
+// diff buffer
+func visitDiffLine() {
+	line := diffText.Index(cursorMark).Line
+	for _, t := range buffers {
+		if t == diffSource && line <= len(diffTargets) {
+			switchBuffer(t)
+			mainText.MarkSet(cursorMark,
+				fmt.Sprintf("%d.0", diffTargets[line-1]))
+			editSeparator(mainText)
+			return
+		}
+	}
+	msgError("Buffer no longer open.")
+}
+
+// Colour added, removed, and hunk header lines of the diff buffer
+func drawDiffColors(t *tktext.TkText, left, width, height int) {
+	cells := termbox.CellBuffer()
+	for y := 0; y < height; y++ {
+		pos := t.Index(fmt.Sprintf("@0,%d", y))
+		if pos.Line < 3 {
+			continue // File header
+		}
+		var fg termbox.Attribute
+		switch t.Get(fmt.Sprintf("%d.0", pos.Line),
+			fmt.Sprintf("%d.1", pos.Line)) {
+		case "+":
+			fg = termbox.ColorGreen
+		case "-":
+			fg = termbox.ColorRed
+		case "@":
+			fg = termbox.ColorCyan
+		default:
+			continue
+		}
+		for x := left; x < width; x++ {
+			if cells[y*width+x].Bg == termbox.ColorDefault {
+				cells[y*width+x].Fg = fg
+			}
+		}
+	}
+}
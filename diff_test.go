@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want []hunk
+	}{
+		{"a\nb\nc", "a\nb\nc", []hunk{}},
+		{"a\nb\nc", "a\nx\nc", []hunk{{2, 1, 2, 1}}},
+		{"a\nc", "a\nb\nc", []hunk{{2, 0, 2, 1}}},
+		{"a\nb\nc", "a\nc", []hunk{{2, 1, 2, 0}}},
+		{"a\nb\nc\nd", "b\nc\nd\ne", []hunk{{1, 1, 1, 0}, {5, 0, 4, 1}}},
+		{"", "x", []hunk{{1, 1, 1, 1}}},
+		{"a\nb", "c\nd", []hunk{{1, 2, 1, 2}}},
+		{"a\nb\nc\na\nb\nb\na", "c\nb\na\nb\na\nc",
+			[]hunk{{1, 2, 1, 0}, {4, 0, 2, 1}, {6, 1, 5, 0}, {8, 0, 6, 1}}},
+	}
+	for _, test := range tests {
+		got := diffLines(strings.Split(test.a, "\n"),
+			strings.Split(test.b, "\n"))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("diffLines(%#v, %#v) == %v; want %v", test.a, test.b,
+				got, test.want)
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := strings.Split("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12", "\n")
+	b := strings.Split("1\ntwo\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13", "\n")
+	lines, targets := unifiedDiff("a", "b", a, b, diffLines(a, b))
+	want := []string{
+		"--- a", "+++ b",
+		"@@ -1,5 +1,5 @@", " 1", "-2", "+two", " 3", " 4", " 5",
+		"@@ -10,3 +10,4 @@", " 10", " 11", " 12", "+13",
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("unifiedDiff() lines == %#v; want %#v", lines, want)
+	}
+	wantTargets := []int{1, 1, 1, 1, 2, 2, 3, 4, 5, 10, 10, 11, 12, 13}
+	if !reflect.DeepEqual(targets, wantTargets) {
+		t.Errorf("unifiedDiff() targets == %v; want %v", targets, wantTargets)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/jangler/tktext"
+	"github.com/nsf/termbox-go"
+)
+
+const gutterWidth = 2
+
+// The HEAD version of a file, if it has one
+type gitBase struct {
+	text string
+	ok   bool
+}
+
+// The hunks of a buffer against the HEAD version of its file, as of a
+// version of its text
+type gitHunkCache struct {
+	history *history
+	version int
+	name    string
+	hunks   []hunk
+}
+
+var (
+	gitBases    = make(map[string]gitBase) // Keyed by absolute path
+	gitBranches = make(map[string]string)  // Keyed by directory
+	gitHunkMap  = make(map[*tktext.TkText]gitHunkCache)
+)
+
+// Toggle git mode, refreshing information from the repository
+func toggleGit() {
+	modeGit = !modeGit
+	gitForget()
+}
+
+// Forget cached repository information, so that it is read again when needed
+func gitForget() {
+	gitBases = make(map[string]gitBase)
+	gitBranches = make(map[string]string)
+	gitHunkMap = make(map[*tktext.TkText]gitHunkCache)
+}
+
+// Run git in the directory and return its output
+func gitOutput(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	p, err := cmd.Output()
+	return string(p), err
+}
+
+// Return the HEAD version of the file at path. Returns false if the file is
+// not tracked by git
+func gitHead(path string) (string, bool) {
+	abs, err := filepath.Abs(expandPath(path))
+	if err != nil {
+		return "", false
+	}
+	if base, ok := gitBases[abs]; ok {
+		return base.text, base.ok
+	}
+	dir, name := filepath.Split(abs)
+	text, err := gitOutput(dir, "show", "HEAD:./"+name)
+	gitBases[abs] = gitBase{text, err == nil}
+	return text, err == nil
+}
+
+// Return the current git branch for the main buffer's file, or "" if none
+func gitBranch() string {
+	dir := "."
+	if filename != "" {
+		dir = filepath.Dir(expandPath(filename))
+	}
+	branch, ok := gitBranches[dir]
+	if !ok {
+		s, err := gitOutput(dir, "rev-parse", "--abbrev-ref", "HEAD")
+		if err == nil {
+			branch = strings.TrimSpace(s)
+		}
+		gitBranches[dir] = branch
+	}
+	return branch
+}
+
+// Return the hunks that differ between the buffer and its file's HEAD
+// version. The hunks are cached until the buffer is edited or saved
+func gitHunks(t *tktext.TkText) []hunk {
+	name := bufferName(t)
+	if name == "" {
+		return nil
+	}
+	h := historyOf(t)
+	if c, ok := gitHunkMap[t]; ok && c.history == h &&
+		c.version == h.version && c.name == name {
+		return c.hunks
+	}
+	var hunks []hunk
+	if base, ok := gitHead(name); ok {
+		hunks = diffLines(strings.Split(base, "\n"),
+			strings.Split(t.Get("1.0", "end"), "\n"))
+	}
+	gitHunkMap[t] = gitHunkCache{h, h.version, name, hunks}
+	return hunks
+}
+
+// Return the buffer line where the hunk is marked. Deletions are marked on
+// the line before them
+func hunkLine(h hunk) int {
+	if h.newLines == 0 && h.newStart > 1 {
+		return h.newStart - 1
+	}
+	return h.newStart
+}
+
+// Return the gutter marker and colour for lines of the hunk
+func hunkMarker(h hunk) (rune, termbox.Attribute) {
+	switch {
+	case h.oldLines == 0:
+		return '+', termbox.ColorGreen
+	case h.newLines == 0:
+		return '_', termbox.ColorRed
+	}
+	return '~', termbox.ColorYellow
+}
+
+// Draw markers for added, modified, and deleted lines in the gutter
+func drawGutter(t *tktext.TkText, height int) {
+	markers := make(map[int]int) // Hunk indices keyed by line
+	hunks := gitHunks(t)
+	for i, h := range hunks {
+		markers[hunkLine(h)] = i
+		for line := h.newStart + 1; line < h.newStart+h.newLines; line++ {
+			markers[line] = i
+		}
+	}
+
+	prevLine := 0
+	for y := 0; y < height; y++ {
+		pos := t.Index(fmt.Sprintf("@0,%d", y))
+		if pos.Line == prevLine {
+			continue // Wrapped line, or past the end of the buffer
+		}
+		prevLine = pos.Line
+		if i, ok := markers[pos.Line]; ok {
+			ch, fg := hunkMarker(hunks[i])
+			termbox.SetCell(0, y, ch, fg, termbox.ColorDefault)
+		}
+	}
+}
+
+// Move the cursor to the hunk d places away
+func nextHunk(d int) {
+	if focusText != mainText {
+		return
+	}
+	if !modeGit {
+		msgError("Git mode is off.")
+		return
+	}
+	hunks := gitHunks(mainText)
+	line := mainText.Index(cursorMark).Line
+	target := -1
+	for i, h := range hunks {
+		if d > 0 && hunkLine(h) > line {
+			target = i
+			break
+		} else if d < 0 && hunkLine(h) < line {
+			target = i
+		}
+	}
+	if target < 0 {
+		msgError("No more changes.")
+		return
+	}
+	mainText.MarkSet(cursorMark, fmt.Sprintf("%d.0", hunkLine(hunks[target])))
+	editSeparator(mainText)
+	msgNormal(fmt.Sprintf("Change %d of %d.", target+1, len(hunks)))
+}
+
+// Revert the hunk at the cursor to its HEAD version
+func revertHunk() {
+	if focusText != mainText {
+		return
+	}
+	if !modeGit {
+		msgError("Git mode is off.")
+		return
+	}
+	if isReadOnly(mainText) {
+		msgError("Buffer is read-only.")
+		return
+	}
+	line := mainText.Index(cursorMark).Line
+	for _, h := range gitHunks(mainText) {
+		if line == hunkLine(h) ||
+			(line >= h.newStart && line < h.newStart+h.newLines) {
+			base, _ := gitHead(filename)
+			old := strings.Split(base, "\n")[h.oldStart-1:][:h.oldLines]
+			editSeparator(mainText)
+			replaceLines(mainText, h.newStart, h.newLines, old)
+			mainText.MarkSet(cursorMark, fmt.Sprintf("%d.0", h.newStart))
+			editSeparator(mainText)
+			msgNormal("Reverted change.")
+			return
+		}
+	}
+	msgError("No change at cursor.")
+}
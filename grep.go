@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jangler/tktext"
+)
+
+var (
+	grepText    *tktext.TkText // Not initialized unless we need it
+	grepPattern string
+	grepDir     = "."
+	grepStop    chan bool // Closed to stop the running search
+	grepCount   int
+)
+
+// A pattern from a .gitignore file
+type ignoreRule struct {
+	pattern                   string
+	negate, dirOnly, anchored bool
+}
+
+// Parse the contents of a .gitignore file
+func parseIgnore(s string) []ignoreRule {
+	rules := make([]ignoreRule, 0)
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimRight(line, " \r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var r ignoreRule
+		if strings.HasPrefix(line, "!") {
+			r.negate, line = true, line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly, line = true, strings.TrimSuffix(line, "/")
+		}
+		line = strings.TrimPrefix(line, "**/")
+		if strings.Contains(line, "/") {
+			r.anchored, line = true, strings.TrimPrefix(line, "/")
+		}
+		r.pattern = line
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// Returns true if the rules ignore the path, given relative to the directory
+// of the .gitignore file. The last matching rule takes precedence
+func ignored(rules []ignoreRule, path string, dir bool) bool {
+	ignore := false
+	for _, r := range rules {
+		if r.dirOnly && !dir {
+			continue
+		}
+		name := filepath.Base(path)
+		if r.anchored {
+			name = path
+		}
+		if ok, _ := filepath.Match(r.pattern, name); ok {
+			ignore = !r.negate
+		}
+	}
+	return ignore
+}
+
+// A set of .gitignore rules and the directory they apply to
+type ignoreFile struct {
+	dir   string
+	rules []ignoreRule
+}
+
+// Walk the files under the directory, skipping those ignored by .gitignore
+// files and binary files, and call found with the path and contents of each.
+// Stops early if stop is closed
+func walkFiles(dir string, ignores []ignoreFile, stop <-chan bool,
+	found func(path string, p []byte)) {
+	if p, err := ioutil.ReadFile(filepath.Join(dir, ".gitignore")); err == nil {
+		ignores = append(ignores, ignoreFile{dir, parseIgnore(string(p))})
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		path := filepath.Join(dir, info.Name())
+		if info.Name() == ".git" || isIgnored(ignores, path, info.IsDir()) {
+			continue
+		}
+		if info.IsDir() {
+			walkFiles(path, ignores, stop, found)
+		} else if info.Mode().IsRegular() {
+			p, err := ioutil.ReadFile(path)
+			if err == nil && !isBinary(p) {
+				found(path, p)
+			}
+		}
+	}
+}
+
+// Returns true if any of the ignore files ignores the path
+func isIgnored(ignores []ignoreFile, path string, dir bool) bool {
+	for i := len(ignores) - 1; i >= 0; i-- {
+		rel, err := filepath.Rel(ignores[i].dir, path)
+		if err != nil {
+			continue
+		}
+		if ignored(ignores[i].rules, filepath.ToSlash(rel), dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// Guess whether file contents are binary, as git and grep do
+func isBinary(p []byte) bool {
+	if len(p) > 8000 {
+		p = p[:8000]
+	}
+	return bytes.IndexByte(p, 0) >= 0
+}
+
+// Return the lines of the file contents that match, formatted as
+// path:line: text
+func grepLines(path string, p []byte, match func(string) bool) []string {
+	lines := make([]string, 0)
+	all := strings.Split(string(p), "\n")
+	if all[len(all)-1] == "" {
+		all = all[:len(all)-1] // Not a line, just the end of the last one
+	}
+	for i, line := range all {
+		if match(line) {
+			lines = append(lines, fmt.Sprintf("%s:%d: %s", path, i+1, line))
+		}
+	}
+	return lines
+}
+
+// Return a function matching lines against the pattern. A pattern enclosed in
+// slashes is a regular expression; otherwise it is literal text
+func grepMatcher(pattern string) (func(string) bool, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") &&
+		strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+	return func(s string) bool {
+		return strings.Contains(s, pattern)
+	}, nil
+}
+
+// Search files under the directory for the grep pattern in the background,
+// streaming results into the grep buffer in batches
+func runGrep(dir string) {
+	if grepPattern == "" {
+		msgError("No search string.")
+		return
+	}
+	match, err := grepMatcher(grepPattern)
+	if err != nil {
+		msgError(err.Error())
+		return
+	}
+	if _, err := os.Stat(expandPath(dir)); err != nil {
+		msgError(err.Error())
+		return
+	}
+	grepDir = dir
+	stopGrep()
+
+	if grepText == nil {
+		grepText = newOutputBuffer()
+	} else {
+		switchBuffer(grepText)
+	}
+	setOutput(grepText, "")
+	grepCount = 0
+	msgNormal(fmt.Sprintf("Searching for \"%s\" in \"%s\".", grepPattern, dir))
+
+	stop := make(chan bool)
+	grepStop = stop
+	go func() {
+		batch := &outputBatch{flush: func(s string) {
+			if grepStop == stop {
+				grepCount += strings.Count(s, "\n")
+				appendOutput(grepText, s)
+			}
+		}}
+		walkFiles(expandPath(dir), nil, stop, func(path string, p []byte) {
+			if lines := grepLines(path, p, match); len(lines) > 0 {
+				batch.add(strings.Join(lines, "\n") + "\n")
+			}
+		})
+		funcChan <- func() {
+			if grepStop == stop {
+				batch.drain()
+				grepStop = nil
+				msgNormal(fmt.Sprintf("%d matches for \"%s\".", grepCount,
+					grepPattern))
+			}
+		}
+	}()
+}
+
+// Stop the running search, if any
+func stopGrep() {
+	if grepStop != nil {
+		close(grepStop)
+		grepStop = nil
+		msgNormal("Stopped search.")
+	}
+}
+
+// Visit the file position described by the current line of a results buffer,
+// in the form path:line: text
+func visitResult() {
+	line := focusText.Get(cursorMark+" linestart", cursorMark+" lineend")
+	if m := errorRegexp.FindStringSubmatch(line); m != nil {
+		var t target
+		t.path = m[1]
+		fmt.Sscan(m[2], &t.line)
+		fmt.Sscan(m[3], &t.col)
+		visitTarget(t)
+	} else {
+		msgError("No file position on line.")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIgnored(t *testing.T) {
+	rules := parseIgnore("# comment\n*.o\n/build\nlogs/\n!keep.o\ndoc/*.html\n")
+	tests := []struct {
+		path string
+		dir  bool
+		want bool
+	}{
+		{"main.go", false, false},
+		{"main.o", false, true},
+		{"sub/main.o", false, true},
+		{"keep.o", false, false},
+		{"build", true, true},
+		{"logs", true, true},
+		{"logs", false, false},
+		{"doc/index.html", false, true},
+		{"sub/doc/index.html", false, false},
+	}
+	for _, test := range tests {
+		if got := ignored(rules, test.path, test.dir); got != test.want {
+			t.Errorf("ignored(%#v, %v) == %v; want %v", test.path, test.dir,
+				got, test.want)
+		}
+	}
+}
+
+func TestWalkFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zygote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		".gitignore":     "*.log\n",
+		"a.txt":          "hello\nworld\n",
+		"b.log":          "hello\n",
+		"sub/c.txt":      "say hello\n",
+		"sub/d.bin":      "hello\x00",
+		".git/HEAD":      "hello\n",
+		"sub/.gitignore": "c.txt\n",
+	}
+	for name, s := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	match, _ := grepMatcher("/^h/")
+	got := make([]string, 0)
+	walkFiles(dir, nil, nil, func(path string, p []byte) {
+		rel, _ := filepath.Rel(dir, path)
+		got = append(got, grepLines(rel, p, match)...)
+	})
+	if want := []string{"a.txt:1: hello"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("walkFiles() found %#v; want %#v", got, want)
+	}
+}
+
+func TestGrepLines(t *testing.T) {
+	match, _ := grepMatcher("//")
+	got := grepLines("f", []byte("a\n\nb\n"), match)
+	want := []string{"f:1: a", "f:2: ", "f:3: b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("grepLines() == %#v; want %#v", got, want)
+	}
+}
+
+func TestRunGrep(t *testing.T) {
+	withTestBuffer(t)
+	oldPattern := grepPattern
+	defer func() { grepPattern, grepText = oldPattern, nil }()
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt"} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte("x\ny\nx\n"),
+			0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	grepPattern = "x"
+	runGrep(dir)
+	runEventsUntil(t, func() bool { return grepStop == nil })
+	if grepCount != 4 {
+		t.Errorf("grepCount == %d; want 4", grepCount)
+	}
+	if got := strings.Count(grepText.Get("1.0", "end"), "\n"); got != 4 {
+		t.Errorf("grep buffer has %d lines; want 4", got)
+	}
+}
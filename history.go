@@ -0,0 +1,589 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jangler/tktext"
+)
+
+// Maximum size of a persistent undo file, in bytes
+const maxUndoFileSize = 1 << 20
+
+// Version of the undo file format
+const undoFileVersion = 2
+
+// Text replaced in a buffer at a position. Lines are numbered from 1, and
+// characters are counted in runes
+type change struct {
+	Line int    `json:"line"`
+	Char int    `json:"char"`
+	Old  string `json:"old"`
+	New  string `json:"new"`
+}
+
+// The changes made to a buffer between two undo separators, in the order they
+// were made
+type edit struct {
+	Changes []change `json:"changes"`
+}
+
+// A state in the undo tree of a buffer. Nodes are numbered in the order they
+// were created
+type undoNode struct {
+	Parent int       `json:"parent"` // -1 for the initial state
+	Child  int       `json:"child"`  // Most recently visited child, or -1
+	Edit   edit      `json:"edit"`   // From the parent's state to this one
+	Time   time.Time `json:"time"`
+}
+
+// The undo tree of a buffer. Changes are recorded as they are made by
+// insertText and deleteText, and become a new state at each undo separator.
+// Making an edit after undoing starts a new branch rather than discarding the
+// undone edits
+type history struct {
+	nodes   []undoNode
+	current int
+	saved   int      // State when last saved, or -1
+	pending []change // Changes since the last separator
+	version int      // Incremented by each change to the text
+}
+
+// Undo history as stored in a persistent undo file
+type undoFile struct {
+	Version  int        `json:"version"`
+	FileHash string     `json:"fileHash"` // Of the file as written
+	TextHash string     `json:"textHash"` // Of the buffer text when written
+	Nodes    []undoNode `json:"nodes"`
+	Current  int        `json:"current"`
+	Saved    int        `json:"saved"`
+}
+
+var (
+	histories    = make(map[*tktext.TkText]*history)
+	noUndoFileIn []string // Directories where undo files are not kept
+
+	undoText   *tktext.TkText // Not initialized unless we need it
+	undoSource *tktext.TkText // Buffer whose history undoText lists
+
+	travelRegexp = regexp.MustCompile(`^([+-]?)(\d+)([smhd]?)$`)
+)
+
+// Return a new history with only the initial state
+func newHistory() *history {
+	return &history{
+		nodes: []undoNode{{Parent: -1, Child: -1, Time: time.Now()}},
+	}
+}
+
+// Return the undo history of the buffer
+func historyOf(t *tktext.TkText) *history {
+	h := histories[t]
+	if h == nil {
+		h = newHistory()
+		histories[t] = h
+	}
+	return h
+}
+
+// Return the position after the text s, if it starts at the given position
+func advance(line, char int, s string) (int, int) {
+	if i := strings.LastIndex(s, "\n"); i >= 0 {
+		return line + strings.Count(s, "\n"), utf8.RuneCountInString(s[i+1:])
+	}
+	return line, char + utf8.RuneCountInString(s)
+}
+
+// Record a change to the buffer's text
+func recordChange(t *tktext.TkText, c change) {
+	h := historyOf(t)
+	h.version++
+	h.pending = append(h.pending, c)
+}
+
+// Insert text into the buffer at the index, recording the change for undo
+func insertText(t *tktext.TkText, index, s string) {
+	pos := t.Index(index)
+	t.Insert(index, s)
+	if !isReadOnly(t) && s != "" {
+		recordChange(t, change{pos.Line, pos.Char, "", s})
+	}
+}
+
+// Delete the text between the indices from the buffer, recording the change
+// for undo
+func deleteText(t *tktext.TkText, index1, index2 string) {
+	pos, s := t.Index(index1), t.Get(index1, index2)
+	t.Delete(index1, index2)
+	if !isReadOnly(t) && s != "" {
+		recordChange(t, change{pos.Line, pos.Char, s, ""})
+	}
+}
+
+// A run of changes being merged into one
+type changeRun struct {
+	change
+	endLine, endChar int      // Position after the new text
+	newText          []byte   // New text typed so far
+	before, after    []string // Old text deleted by Backspace and Delete
+}
+
+// Merge the change into the run if it continues it: typing at the end of the
+// text typed so far, deleting back into that text, or deleting repeatedly
+// at the same position in either direction. Returns false if it doesn't
+func (r *changeRun) merge(c change) bool {
+	endLine, endChar := advance(c.Line, c.Char, c.Old)
+	switch {
+	case c.Old == "" && c.Line == r.endLine && c.Char == r.endChar:
+		r.newText = append(r.newText, c.New...)
+		r.endLine, r.endChar = advance(r.endLine, r.endChar, c.New)
+	case c.New == "" && endLine == r.endLine && endChar == r.endChar &&
+		len(r.newText) >= len(c.Old) &&
+		string(r.newText[len(r.newText)-len(c.Old):]) == c.Old:
+		r.newText = r.newText[:len(r.newText)-len(c.Old)]
+		r.endLine, r.endChar = c.Line, c.Char
+	case c.New == "" && len(r.newText) == 0 && endLine == r.Line &&
+		endChar == r.Char:
+		r.before = append(r.before, c.Old)
+		r.Line, r.Char, r.endLine, r.endChar = c.Line, c.Char, c.Line, c.Char
+	case c.New == "" && len(r.newText) == 0 && c.Line == r.Line &&
+		c.Char == r.Char:
+		r.after = append(r.after, c.Old)
+	default:
+		return false
+	}
+	return true
+}
+
+// Return the change the run amounts to
+func (r *changeRun) result() change {
+	c := r.change
+	var old strings.Builder
+	for i := len(r.before) - 1; i >= 0; i-- {
+		old.WriteString(r.before[i])
+	}
+	old.WriteString(c.Old)
+	for _, s := range r.after {
+		old.WriteString(s)
+	}
+	c.Old, c.New = old.String(), string(r.newText)
+	return c
+}
+
+// Return the changes with runs of typing and deleting merged, so that a word
+// typed a character at a time is kept as one change
+func mergeChanges(changes []change) []change {
+	merged := make([]change, 0)
+	var run *changeRun
+	for _, c := range changes {
+		if run != nil && run.merge(c) {
+			continue
+		}
+		if run != nil {
+			merged = append(merged, run.result())
+		}
+		run = &changeRun{change: change{c.Line, c.Char, c.Old, ""},
+			newText: []byte(c.New)}
+		run.endLine, run.endChar = advance(c.Line, c.Char, c.New)
+	}
+	if run != nil {
+		merged = append(merged, run.result())
+	}
+	return merged
+}
+
+// Insert an undo separator into the buffer, making any changes since the last
+// separator a new state
+func editSeparator(t *tktext.TkText) {
+	t.EditSeparator()
+	if isReadOnly(t) {
+		return
+	}
+	h := historyOf(t)
+	if len(h.pending) == 0 {
+		return
+	}
+	h.nodes = append(h.nodes, undoNode{h.current, -1,
+		edit{mergeChanges(h.pending)}, time.Now()})
+	h.nodes[h.current].Child = len(h.nodes) - 1
+	h.current = len(h.nodes) - 1
+	h.pending = nil
+}
+
+// Clear the undo history of the buffer
+func editReset(t *tktext.TkText) {
+	t.EditReset()
+	histories[t] = newHistory()
+}
+
+// Record that the buffer's current state is the saved one
+func editSaved(t *tktext.TkText) {
+	editSeparator(t)
+	h := historyOf(t)
+	h.saved = h.current
+	t.EditSetModified(false)
+}
+
+// Apply an edit to the buffer, or revert it if reverse is true. Returns the
+// index where the last change applied begins
+func applyEdit(t *tktext.TkText, e edit, reverse bool) string {
+	index := t.Index(cursorMark).String()
+	for i := range e.Changes {
+		c := e.Changes[i]
+		from, to := c.Old, c.New
+		if reverse {
+			c = e.Changes[len(e.Changes)-1-i]
+			from, to = c.New, c.Old
+		}
+		index = fmt.Sprintf("%d.%d", c.Line, c.Char)
+		line, char := advance(c.Line, c.Char, from)
+		t.Delete(index, fmt.Sprintf("%d.%d", line, char))
+		t.Insert(index, to)
+	}
+	return index
+}
+
+// Return the path of states from the root to the node, inclusive
+func rootPath(h *history, node int) []int {
+	path := make([]int, 0)
+	for ; node >= 0; node = h.nodes[node].Parent {
+		path = append([]int{node}, path...)
+	}
+	return path
+}
+
+// Move the buffer to the given state of its history, reverting edits back to
+// the common ancestor of the current and target states, then applying edits
+// forward to the target
+func gotoState(t *tktext.TkText, target int) {
+	editSeparator(t)
+	h := historyOf(t)
+	from, to := rootPath(h, h.current), rootPath(h, target)
+	common := 0
+	for common < len(from) && common < len(to) && from[common] == to[common] {
+		common++
+	}
+
+	index := t.Index(cursorMark).String()
+	for i := len(from) - 1; i >= common; i-- {
+		index = applyEdit(t, h.nodes[from[i]].Edit, true)
+		h.nodes[h.nodes[from[i]].Parent].Child = from[i]
+	}
+	for i := common; i < len(to); i++ {
+		index = applyEdit(t, h.nodes[to[i]].Edit, false)
+		h.nodes[h.nodes[to[i]].Parent].Child = to[i]
+	}
+
+	h.current = target
+	h.version++
+	t.MarkSet(cursorMark, index)
+	t.EditSeparator()
+	t.EditSetModified(h.current != h.saved)
+}
+
+// Move the buffer through its history by one edit, backward if undoing, and
+// forward along the most recently visited branch if redoing. Returns false if
+// there is nothing to undo or redo
+func stepHistory(t *tktext.TkText, undoing bool) bool {
+	editSeparator(t)
+	h := historyOf(t)
+	target := h.nodes[h.current].Child
+	if undoing {
+		target = h.nodes[h.current].Parent
+	}
+	if target < 0 {
+		return false
+	}
+	gotoState(t, target)
+	return true
+}
+
+// Move the main buffer to an earlier or later state chronologically. The
+// argument is a signed number of states, like -3, or a signed duration, like
+// -5m; units are s, m, h, and d
+func travelHistory(arg string) {
+	if focusText != mainText {
+		return
+	}
+	if isReadOnly(mainText) {
+		msgError("Buffer is read-only.")
+		return
+	}
+	m := travelRegexp.FindStringSubmatch(strings.TrimSpace(arg))
+	if m == nil {
+		msgError("Invalid state or time: " + arg)
+		return
+	}
+	editSeparator(mainText)
+	h := historyOf(mainText)
+	n, _ := strconv.Atoi(m[2])
+	if m[1] == "-" {
+		n = -n
+	}
+
+	target := n // Absolute state number
+	if m[3] != "" {
+		// Latest state no later than the offset from the current one
+		unit := map[string]time.Duration{"s": time.Second, "m": time.Minute,
+			"h": time.Hour, "d": 24 * time.Hour}[m[3]]
+		when := h.nodes[h.current].Time.Add(time.Duration(n) * unit)
+		target = 0
+		for i, node := range h.nodes {
+			if !node.Time.After(when) {
+				target = i
+			}
+		}
+	} else if m[1] != "" {
+		target = h.current + n
+	}
+	if target < 0 {
+		target = 0
+	} else if target >= len(h.nodes) {
+		target = len(h.nodes) - 1
+	}
+
+	gotoState(mainText, target)
+	msgNormal(fmt.Sprintf("State %d of %d, from %s.", target,
+		len(h.nodes)-1, h.nodes[target].Time.Format("15:04:05")))
+}
+
+// Return the lines of the undo history listing, one per branch, newest first
+func historyLines(h *history) []string {
+	children := make(map[int]int)
+	for _, node := range h.nodes {
+		children[node.Parent]++
+	}
+	onPath := make(map[int]bool)
+	for _, node := range rootPath(h, h.current) {
+		onPath[node] = true
+	}
+
+	lines := make([]string, 0)
+	for i := len(h.nodes) - 1; i >= 0; i-- {
+		if children[i] > 0 && i != h.current {
+			continue
+		}
+		// Count the edits since the branch left the current path
+		edits, added, removed := 0, 0, 0
+		for node := i; node >= 0 && !onPath[node]; node = h.nodes[node].Parent {
+			edits++
+			for _, c := range h.nodes[node].Edit.Changes {
+				added += utf8.RuneCountInString(c.New)
+				removed += utf8.RuneCountInString(c.Old)
+			}
+		}
+		line := fmt.Sprintf("%d  %s  %d edits, +%d -%d chars", i,
+			h.nodes[i].Time.Format("2006-01-02 15:04:05"), edits, added,
+			removed)
+		if i == h.current {
+			line = fmt.Sprintf("%d  %s  current", i,
+				h.nodes[i].Time.Format("2006-01-02 15:04:05"))
+		}
+		if i == h.saved {
+			line += ", saved"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Show the branches of the main buffer's undo history in a read-only buffer
+func showHistory() {
+	if focusText != mainText || isReadOnly(mainText) {
+		return
+	}
+	editSeparator(mainText)
+	undoSource = mainText
+	lines := historyLines(historyOf(mainText))
+	if undoText == nil {
+		undoText = newOutputBuffer()
+	} else {
+		switchBuffer(undoText)
+	}
+	setOutput(undoText, strings.Join(lines, "\n"))
+	undoText.MarkSet(cursorMark, "1.0")
+	msgNormal("Press Enter on a line to restore that state.")
+}
+
+// Restore the state listed on the cursor line of the undo history buffer
+func visitHistoryLine() {
+	line := undoText.Get(cursorMark+" linestart", cursorMark+" lineend")
+	n, err := strconv.Atoi(strings.SplitN(line, " ", 2)[0])
+	if err != nil {
+		msgError("No state on line.")
+		return
+	}
+	for _, t := range buffers {
+		if t == undoSource {
+			switchBuffer(t)
+			if h := historyOf(t); n < len(h.nodes) {
+				gotoState(t, n)
+				msgNormal(fmt.Sprintf("Restored state %d.", n))
+			}
+			return
+		}
+	}
+	msgError("Buffer no longer open.")
+}
+
+// Return the directory where persistent state is kept
+func stateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "zygote")
+	}
+	return expandPath("~/.local/state/zygote")
+}
+
+// Return the path of the undo file for the file at path, or "" if undo files
+// are disabled for it
+func undoFilePath(path string) string {
+	abs, err := filepath.Abs(expandPath(path))
+	if err != nil {
+		return ""
+	}
+	for _, dir := range noUndoFileIn {
+		if abs == dir || strings.HasPrefix(abs, dir+string(filepath.Separator)) {
+			return ""
+		}
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(stateDir(), "undo", hex.EncodeToString(sum[:]))
+}
+
+// Return the hex SHA-256 hash of the data
+func hashString(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// Return the history reduced to the given states, which must include each
+// state's parent unless it is to become a root. Returns the new indices of
+// the current and saved states (-1 if dropped)
+func pruneNodes(nodes []undoNode, keep []int, current,
+	saved int) ([]undoNode, int, int) {
+	sort.Ints(keep)
+	index := make(map[int]int)
+	for i, node := range keep {
+		index[node] = i
+	}
+	pruned := make([]undoNode, len(keep))
+	for i, node := range keep {
+		pruned[i] = nodes[node]
+		if p, ok := index[pruned[i].Parent]; ok {
+			pruned[i].Parent = p
+		} else {
+			pruned[i].Parent, pruned[i].Edit = -1, edit{}
+		}
+		if c, ok := index[pruned[i].Child]; ok {
+			pruned[i].Child = c
+		} else {
+			pruned[i].Child = -1
+		}
+	}
+	newSaved, ok := index[saved]
+	if !ok {
+		newSaved = -1
+	}
+	return pruned, index[current], newSaved
+}
+
+// Write the buffer's undo history for the file at path, which has just been
+// written with the contents p. To fit the size limit, other branches are
+// dropped first, then the oldest states
+func writeUndoFile(t *tktext.TkText, path string, p []byte) error {
+	undoPath := undoFilePath(path)
+	if undoPath == "" {
+		return nil
+	}
+	h := historyOf(t)
+	u := undoFile{undoFileVersion, hashString(string(p)),
+		hashString(t.Get("1.0", "end")), h.nodes, h.current, h.saved}
+	for {
+		data, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		if len(data) <= maxUndoFileSize || len(u.Nodes) == 1 {
+			if err := os.MkdirAll(filepath.Dir(undoPath), 0700); err != nil {
+				return err
+			}
+			return ioutil.WriteFile(undoPath, data, 0600)
+		}
+		path := rootPath(&history{nodes: u.Nodes}, u.Current)
+		if len(path) < len(u.Nodes) {
+			u.Nodes, u.Current, u.Saved = pruneNodes(u.Nodes, path, u.Current,
+				u.Saved)
+		} else {
+			u.Nodes, u.Current, u.Saved = pruneNodes(u.Nodes, path[1:],
+				u.Current, u.Saved)
+		}
+	}
+}
+
+// Restore the buffer's undo history for the file at path, which has just been
+// read with the contents p, if the file is unchanged since it was written.
+// Returns an error if the undo file is corrupt
+func readUndoFile(t *tktext.TkText, path string, p []byte) error {
+	undoPath := undoFilePath(path)
+	if undoPath == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(undoPath)
+	if err != nil {
+		return nil
+	}
+	var u undoFile
+	if json.Unmarshal(data, &u) != nil || u.Version != undoFileVersion ||
+		u.FileHash != hashString(string(p)) {
+		return nil // From an older version, or for another file
+	}
+	if !validUndoFile(u) {
+		return errors.New("Invalid undo file: " + undoPath)
+	}
+
+	// The buffer may have lacked the final newline added when saving
+	text := t.Get("1.0", "end")
+	if u.TextHash != hashString(text) {
+		if !strings.HasSuffix(text, "\n") ||
+			u.TextHash != hashString(text[:len(text)-1]) {
+			return nil
+		}
+		t.Delete("end-1c", "end")
+	}
+	histories[t] = &history{nodes: u.Nodes, current: u.Current, saved: u.Saved}
+	t.EditReset()
+	t.EditSetModified(false)
+	return nil
+}
+
+// Returns true if the states of the undo file refer only to states that
+// exist. Parents come before their children, and a state whose parent was
+// pruned has none
+func validUndoFile(u undoFile) bool {
+	n := len(u.Nodes)
+	if u.Current < 0 || u.Current >= n || u.Saved < -1 || u.Saved >= n {
+		return false
+	}
+	for i, node := range u.Nodes {
+		if node.Parent < -1 || node.Parent >= i {
+			return false
+		}
+		if node.Child != -1 && (node.Child <= i || node.Child >= n ||
+			u.Nodes[node.Child].Parent != i) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/jangler/tktext"
+)
+
+func TestHistory(t *testing.T) {
+	text := tktext.New()
+	text.Insert("end", "one\ntwo\nthree\n")
+	text.MarkSet(cursorMark, "1.0")
+	editReset(text)
+
+	insertText(text, "2.0", "2 ")
+	editSeparator(text)
+	deleteText(text, "3.0", "4.0")
+	insertText(text, "end", "four")
+	editSeparator(text)
+
+	if got, want := text.Get("1.0", "end"), "one\n2 two\nfour"; got != want {
+		t.Fatalf("text == %#v; want %#v", got, want)
+	}
+	for _, want := range []string{"one\n2 two\nthree\n", "one\ntwo\nthree\n"} {
+		if !stepHistory(text, true) {
+			t.Fatalf("stepHistory(undo) == false")
+		}
+		if got := text.Get("1.0", "end"); got != want {
+			t.Errorf("after undo, text == %#v; want %#v", got, want)
+		}
+	}
+	if stepHistory(text, true) {
+		t.Errorf("stepHistory(undo) == true at start of history")
+	}
+	stepHistory(text, false)
+	if got, want := text.Get("1.0", "end"), "one\n2 two\nthree\n"; got != want {
+		t.Errorf("after redo, text == %#v; want %#v", got, want)
+	}
+}
+
+func TestUndoTree(t *testing.T) {
+	text := withTestBuffer(t)
+	text.Insert("end", "a\n")
+	text.MarkSet(cursorMark, "1.0")
+	editReset(text)
+
+	insertText(text, "end", "b\n")
+	editSeparator(text) // State 1
+	stepHistory(text, true)
+	insertText(text, "end", "c\n")
+	editSeparator(text) // State 2, a sibling of state 1
+
+	if got, want := len(historyLines(historyOf(text))), 2; got != want {
+		t.Errorf("len(historyLines()) == %d; want %d", got, want)
+	}
+	for _, c := range []struct {
+		arg, want string
+	}{
+		{"1", "a\nb\n"},
+		{"+1", "a\nc\n"},
+		{"-2", "a\n"},
+		{"+9", "a\nc\n"},
+	} {
+		travelHistory(c.arg)
+		if got := text.Get("1.0", "end"); got != c.want {
+			t.Errorf("travelHistory(%#v): text == %#v; want %#v", c.arg, got,
+				c.want)
+		}
+	}
+
+	// Redo follows the most recently visited branch
+	travelHistory("1")
+	stepHistory(text, true)
+	stepHistory(text, false)
+	if got, want := text.Get("1.0", "end"), "a\nb\n"; got != want {
+		t.Errorf("after redo, text == %#v; want %#v", got, want)
+	}
+}
+
+func TestMergeChanges(t *testing.T) {
+	text := tktext.New()
+	text.Insert("end", "one two\nthree\n")
+	text.MarkSet(cursorMark, "1.0")
+	editReset(text)
+
+	// Type, backspace over part of it, and delete both ways from elsewhere
+	for i, s := range []string{"x", "y", "z"} {
+		insertText(text, fmt.Sprintf("1.%d", 3+i), s)
+	}
+	deleteText(text, "1.5", "1.6")
+	deleteText(text, "1.4", "1.5")
+	editSeparator(text)
+	deleteText(text, "2.1", "2.2")
+	deleteText(text, "2.0", "2.1")
+	deleteText(text, "2.0", "2.1")
+	editSeparator(text)
+
+	h := historyOf(text)
+	for i, want := range []int{1, 1} {
+		if got := len(h.nodes[i+1].Edit.Changes); got != want {
+			t.Errorf("len(state %d changes) == %d; want %d", i+1, got, want)
+		}
+	}
+	if got, want := text.Get("1.0", "end"), "onex two\nee\n"; got != want {
+		t.Fatalf("text == %#v; want %#v", got, want)
+	}
+	stepHistory(text, true)
+	if got, want := text.Get("1.0", "end"), "onex two\nthree\n"; got != want {
+		t.Errorf("after undo, text == %#v; want %#v", got, want)
+	}
+	stepHistory(text, true)
+	if got, want := text.Get("1.0", "end"), "one two\nthree\n"; got != want {
+		t.Errorf("after undo, text == %#v; want %#v", got, want)
+	}
+	stepHistory(text, false)
+	stepHistory(text, false)
+	if got, want := text.Get("1.0", "end"), "onex two\nee\n"; got != want {
+		t.Errorf("after redo, text == %#v; want %#v", got, want)
+	}
+}
+
+func TestPruneNodes(t *testing.T) {
+	nodes := []undoNode{{Parent: -1, Child: 2}, {Parent: 0, Child: -1},
+		{Parent: 0, Child: 3}, {Parent: 2, Child: -1}}
+	pruned, current, saved := pruneNodes(nodes, []int{2, 3}, 3, 1)
+	if len(pruned) != 2 || pruned[0].Parent != -1 || pruned[1].Parent != 0 ||
+		pruned[0].Child != 1 || current != 1 || saved != -1 {
+		t.Errorf("pruneNodes() == %#v, %d, %d", pruned, current, saved)
+	}
+}
+
+func TestUndoFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", dir)
+	path := dir + "/file.txt"
+
+	text := tktext.New()
+	text.MarkSet(cursorMark, "1.0")
+	editReset(text)
+	insertText(text, "end", "hello")
+	editSeparator(text)
+	insertText(text, "end", " world")
+	p := bufferBytes(text)
+	editSaved(text)
+	if err := writeUndoFile(text, path, p); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unchanged file: history is restored
+	reopened := tktext.New()
+	reopened.Insert("end", string(p))
+	reopened.MarkSet(cursorMark, "1.0")
+	editReset(reopened)
+	if err := readUndoFile(reopened, path, p); err != nil {
+		t.Fatal(err)
+	}
+	stepHistory(reopened, true)
+	if got, want := reopened.Get("1.0", "end"), "hello"; got != want {
+		t.Errorf("after undo, text == %#v; want %#v", got, want)
+	}
+
+	// Changed file: history is not restored
+	changed := tktext.New()
+	changed.Insert("end", "other\n")
+	editReset(changed)
+	readUndoFile(changed, path, []byte("other\n"))
+	if stepHistory(changed, true) {
+		t.Errorf("history restored for changed file")
+	}
+
+	// Corrupt file: history is not restored
+	data, err := ioutil.ReadFile(undoFilePath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var u undoFile
+	json.Unmarshal(data, &u)
+	u.Nodes[1].Parent = 7
+	if data, err = json.Marshal(u); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(undoFilePath(path), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := tktext.New()
+	corrupt.Insert("end", string(p))
+	editReset(corrupt)
+	if err := readUndoFile(corrupt, path, p); err == nil {
+		t.Errorf("readUndoFile() with corrupt file == nil; want error")
+	}
+	if stepHistory(corrupt, true) {
+		t.Errorf("history restored from corrupt file")
+	}
+}
@@ -0,0 +1,842 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jangler/tktext"
+	"github.com/nsf/termbox-go"
+)
+
+// A JSON-RPC message, as exchanged with a language server
+type lspMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+type lspCompletionItem struct {
+	Label      string       `json:"label"`
+	InsertText string       `json:"insertText"`
+	TextEdit   *lspTextEdit `json:"textEdit"`
+}
+
+// A connection to a language server. Callbacks are invoked from the goroutine
+// reading server output. Messages to the server are queued and written by
+// another goroutine, so that sending never blocks on a server that is itself
+// blocked writing to us
+type lspClient struct {
+	w       io.Writer
+	mutex   sync.Mutex // Guards the fields below
+	nextID  int
+	pending map[int]func(json.RawMessage, error)
+	handler func(method string, params json.RawMessage)
+	queue   [][]byte   // Messages waiting to be written
+	ready   *sync.Cond // Signalled when the queue grows or the client closes
+	closed  bool
+	done    chan struct{} // Closed by stop
+}
+
+// Return a client that writes to and reads from a language server, and calls
+// handler with server notifications
+func newLSPClient(r io.Reader, w io.Writer,
+	handler func(string, json.RawMessage)) *lspClient {
+	c := &lspClient{
+		w:       w,
+		pending: make(map[int]func(json.RawMessage, error)),
+		handler: handler,
+		done:    make(chan struct{}),
+	}
+	c.ready = sync.NewCond(&c.mutex)
+	go c.readLoop(bufio.NewReader(r))
+	go c.writeLoop()
+	return c
+}
+
+// Read a Content-Length framed message
+func readLSPMessage(r *bufio.Reader) (lspMessage, error) {
+	var msg lspMessage
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return msg, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(strings.ToLower(line), "content-length:") {
+			length, err = strconv.Atoi(strings.TrimSpace(line[15:]))
+			if err != nil {
+				return msg, err
+			}
+		}
+	}
+	if length < 0 {
+		return msg, errors.New("Missing Content-Length header.")
+	}
+	p := make([]byte, length)
+	if _, err := io.ReadFull(r, p); err != nil {
+		return msg, err
+	}
+	err := json.Unmarshal(p, &msg)
+	return msg, err
+}
+
+// Return the message with Content-Length framing
+func encodeLSPMessage(msg lspMessage) ([]byte, error) {
+	msg.JSONRPC = "2.0"
+	p, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(p), p)), nil
+}
+
+// Write a Content-Length framed message
+func writeLSPMessage(w io.Writer, msg lspMessage) error {
+	p, err := encodeLSPMessage(msg)
+	if err == nil {
+		_, err = w.Write(p)
+	}
+	return err
+}
+
+// Queue a message for the server. The caller must hold c.mutex
+func (c *lspClient) send(msg lspMessage) error {
+	p, err := encodeLSPMessage(msg)
+	if err != nil {
+		return err
+	}
+	c.queue = append(c.queue, p)
+	c.ready.Signal()
+	return nil
+}
+
+// Write queued messages to the server until the client closes or a write
+// fails
+func (c *lspClient) writeLoop() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for {
+		for len(c.queue) == 0 && !c.closed {
+			c.ready.Wait()
+		}
+		if len(c.queue) == 0 {
+			return
+		}
+		queue := c.queue
+		c.queue = nil
+		c.mutex.Unlock()
+		for _, p := range queue {
+			if _, err := c.w.Write(p); err != nil {
+				c.mutex.Lock()
+				return
+			}
+		}
+		c.mutex.Lock()
+	}
+}
+
+// Dispatch messages from the server until its output ends
+func (c *lspClient) readLoop(r *bufio.Reader) {
+	var err error
+	for {
+		var msg lspMessage
+		if msg, err = readLSPMessage(r); err != nil {
+			break
+		}
+		switch {
+		case msg.Method != "" && msg.ID != nil:
+			// Requests from the server get a null result, which is enough
+			// to keep common servers going
+			c.mutex.Lock()
+			c.send(lspMessage{ID: msg.ID, Result: json.RawMessage("null")})
+			c.mutex.Unlock()
+		case msg.Method != "":
+			c.handler(msg.Method, msg.Params)
+		default:
+			var id int
+			json.Unmarshal(msg.ID, &id)
+			c.mutex.Lock()
+			callback := c.pending[id]
+			delete(c.pending, id)
+			c.mutex.Unlock()
+			if callback == nil {
+				continue
+			}
+			if msg.Error != nil {
+				callback(nil, errors.New(msg.Error.Message))
+			} else {
+				callback(msg.Result, nil)
+			}
+		}
+	}
+
+	// Fail requests that will never be answered
+	c.mutex.Lock()
+	pending := c.pending
+	c.pending = make(map[int]func(json.RawMessage, error))
+	c.closed = true
+	c.ready.Signal()
+	c.mutex.Unlock()
+	for _, callback := range pending {
+		callback(nil, err)
+	}
+}
+
+// Run f in the event loop, unless the client is stopped first. Callbacks use
+// this so that the read loop never waits on an event loop that has quit
+func (c *lspClient) post(f func()) {
+	select {
+	case funcChan <- f:
+	case <-c.done:
+	}
+}
+
+// Stop passing server messages to the event loop
+func (c *lspClient) stop() {
+	close(c.done)
+}
+
+// Return the JSON encoding of the parameters. Nil parameters are omitted from
+// the message rather than sent as null, which some servers reject
+func lspParams(params interface{}) (json.RawMessage, error) {
+	if params == nil {
+		return nil, nil
+	}
+	return json.Marshal(params)
+}
+
+// Send a request to the server. The callback receives the result
+func (c *lspClient) call(method string, params interface{},
+	callback func(json.RawMessage, error)) error {
+	p, err := lspParams(params)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.nextID++
+	c.pending[c.nextID] = callback
+	id := json.RawMessage(strconv.Itoa(c.nextID))
+	err = c.send(lspMessage{ID: id, Method: method, Params: p})
+	if err != nil {
+		delete(c.pending, c.nextID)
+	}
+	return err
+}
+
+// Send a notification to the server
+func (c *lspClient) notify(method string, params interface{}) error {
+	p, err := lspParams(params)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.send(lspMessage{Method: method, Params: p})
+}
+
+// Time to wait for the language server to exit before killing it
+const lspExitTimeout = time.Second
+
+// A state of a buffer's undo history, identifying the text last sent to the
+// language server
+type lspState struct {
+	h       *history
+	current int
+}
+
+var (
+	lsp         *lspClient // Nil unless LSP mode is active
+	lspCmd      *exec.Cmd
+	lspExited   chan struct{} // Closed when the language server exits
+	lspReady    bool
+	lspURIs     = make(map[*tktext.TkText]string) // URIs of opened documents
+	lspStates   = make(map[*tktext.TkText]lspState)
+	lspVersions = make(map[*tktext.TkText]int)
+	diagnostics = make(map[string][]lspDiagnostic) // Keyed by URI
+	completions []lspCompletionItem
+
+	languageIDs = map[string]string{
+		".c":    "c",
+		".cpp":  "cpp",
+		".go":   "go",
+		".h":    "c",
+		".js":   "javascript",
+		".json": "json",
+		".py":   "python",
+		".rs":   "rust",
+		".sh":   "shellscript",
+		".ts":   "typescript",
+	}
+)
+
+// Return the file URI for the path
+func pathURI(path string) string {
+	if abs, err := filepath.Abs(expandPath(path)); err == nil {
+		path = abs
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+// Return the path for the file URI
+func uriPath(uri string) string {
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		path := u.Path
+		if wd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(wd, path); err == nil &&
+				!strings.HasPrefix(rel, "..") {
+				return rel
+			}
+		}
+		return path
+	}
+	return uri
+}
+
+// Return the number of UTF-16 code units in s, in which LSP measures columns
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// Convert an LSP position to an index in the buffer
+func lspIndex(t *tktext.TkText, pos lspPosition) string {
+	line := t.Get(fmt.Sprintf("%d.0", pos.Line+1),
+		fmt.Sprintf("%d.0 lineend", pos.Line+1))
+	char, units := 0, 0
+	for _, r := range line {
+		if units >= pos.Character {
+			break
+		}
+		units += utf16Len(string(r))
+		char++
+	}
+	return fmt.Sprintf("%d.%d", pos.Line+1, char)
+}
+
+// Convert a buffer index to an LSP position
+func indexLSP(t *tktext.TkText, index string) lspPosition {
+	pos := t.Index(index)
+	prefix := t.Get(fmt.Sprintf("%d.0", pos.Line), pos.String())
+	return lspPosition{pos.Line - 1, utf16Len(prefix)}
+}
+
+// Return the parameters identifying the cursor position in the main buffer
+func cursorParams() map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": pathURI(filename)},
+		"position":     indexLSP(mainText, cursorMark),
+	}
+}
+
+// Toggle LSP mode, starting or stopping the language server in register P
+func toggleLSP() {
+	if lsp != nil {
+		stopLSP()
+		return
+	}
+
+	cmd := exec.Command(shellPath(), "-c", getRegister('P'))
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		msgError(err.Error())
+		return
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		msgError(err.Error())
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		msgError(err.Error())
+		return
+	}
+	exited := make(chan struct{})
+	lspCmd, lspExited = cmd, exited
+	var client *lspClient
+	client = newLSPClient(r, w, func(method string, params json.RawMessage) {
+		client.post(func() { handleLSPNotification(method, params) })
+	})
+	lsp, modeLSP = client, true
+
+	go func() {
+		err := cmd.Wait()
+		close(exited)
+		client.post(func() {
+			if lspCmd == cmd {
+				resetLSP()
+				if err != nil {
+					msgError("Language server exited: " + err.Error())
+				} else {
+					msgError("Language server exited.")
+				}
+			}
+		})
+	}()
+
+	wd, _ := os.Getwd()
+	params := map[string]interface{}{
+		"processId": os.Getpid(),
+		"rootUri":   pathURI(wd),
+		"capabilities": map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"hover": map[string]interface{}{
+					"contentFormat": []string{"plaintext"},
+				},
+				"completion": map[string]interface{}{
+					"completionItem": map[string]bool{"snippetSupport": false},
+				},
+				"publishDiagnostics": map[string]interface{}{},
+			},
+		},
+	}
+	lspCall("initialize", params, func(result json.RawMessage) {
+		lsp.notify("initialized", map[string]interface{}{})
+		lspReady = true
+		lspSync()
+		msgNormal("Language server ready.")
+	})
+}
+
+// Shut down the language server, killing it if it doesn't exit in time.
+// Returns a channel that is closed once the server is gone
+func stopLSP() <-chan struct{} {
+	client, cmd, exited := lsp, lspCmd, lspExited
+	client.call("shutdown", nil, func(json.RawMessage, error) {
+		client.notify("exit", nil)
+	})
+	client.stop() // The event loop may be gone, as when quitting
+	resetLSP()
+	msgNormal("Stopped language server.")
+
+	gone := make(chan struct{})
+	go func() {
+		select {
+		case <-exited:
+		case <-time.After(lspExitTimeout):
+			cmd.Process.Kill()
+		}
+		close(gone)
+	}()
+	return gone
+}
+
+// Forget language server state
+func resetLSP() {
+	lsp, lspCmd, lspExited, lspReady, modeLSP = nil, nil, nil, false, false
+	lspURIs = make(map[*tktext.TkText]string)
+	lspStates = make(map[*tktext.TkText]lspState)
+	lspVersions = make(map[*tktext.TkText]int)
+	diagnostics = make(map[string][]lspDiagnostic)
+}
+
+// Send a request to the language server, and call f with the result in the
+// event loop. Errors are reported in the status line
+func lspCall(method string, params interface{}, f func(json.RawMessage)) {
+	if lsp == nil {
+		msgError("LSP mode is off.")
+		return
+	}
+	c := lsp
+	err := c.call(method, params, func(result json.RawMessage, err error) {
+		c.post(func() {
+			if err != nil {
+				msgError(err.Error())
+			} else {
+				f(result)
+			}
+		})
+	})
+	if err != nil {
+		msgError(err.Error())
+	}
+}
+
+// Handle a notification from the language server
+func handleLSPNotification(method string, params json.RawMessage) {
+	switch method {
+	case "textDocument/publishDiagnostics":
+		var p struct {
+			URI         string          `json:"uri"`
+			Diagnostics []lspDiagnostic `json:"diagnostics"`
+		}
+		if err := json.Unmarshal(params, &p); err == nil {
+			diagnostics[p.URI] = p.Diagnostics
+		}
+	case "window/showMessage":
+		var p struct {
+			Type    int    `json:"type"`
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(params, &p); err == nil && p.Type == 1 {
+			msgError(p.Message)
+		}
+	}
+}
+
+// Inform the language server of opened, closed, and changed buffers. Changed
+// text is sent once it reaches a new state of the buffer's undo history, so
+// that it is not sent for every key typed
+func lspSync() {
+	if !lspReady {
+		return
+	}
+	for _, t := range buffers {
+		name := bufferName(t)
+		uri := ""
+		if name != "" && !isReadOnly(t) {
+			uri = pathURI(name)
+		}
+		if lspURIs[t] != "" && lspURIs[t] != uri {
+			lsp.notify("textDocument/didClose", map[string]interface{}{
+				"textDocument": map[string]string{"uri": lspURIs[t]},
+			})
+			delete(diagnostics, lspURIs[t])
+			delete(lspURIs, t)
+		}
+		if uri == "" {
+			continue
+		}
+
+		state := lspState{historyOf(t), historyOf(t).current}
+		if lspURIs[t] != "" && lspStates[t] == state {
+			continue
+		}
+		text := t.Get("1.0", "end")
+		if lspURIs[t] == "" {
+			languageID := languageIDs[filepath.Ext(name)]
+			if languageID == "" {
+				languageID = "plaintext"
+			}
+			lspVersions[t]++
+			lsp.notify("textDocument/didOpen", map[string]interface{}{
+				"textDocument": map[string]interface{}{
+					"uri":        uri,
+					"languageId": languageID,
+					"version":    lspVersions[t],
+					"text":       text,
+				},
+			})
+			lspURIs[t] = uri
+		} else {
+			lspVersions[t]++
+			lsp.notify("textDocument/didChange", map[string]interface{}{
+				"textDocument": map[string]interface{}{
+					"uri":     uri,
+					"version": lspVersions[t],
+				},
+				"contentChanges": []map[string]string{{"text": text}},
+			})
+		}
+		lspStates[t] = state
+	}
+}
+
+// Return true if the main buffer can be the subject of LSP requests
+func lspAvailable() bool {
+	if lsp == nil {
+		msgError("LSP mode is off. Press M-l to start the language server.")
+		return false
+	}
+	if focusText != mainText || filename == "" {
+		return false
+	}
+	editSeparator(mainText)
+	lspSync()
+	return true
+}
+
+// Show hover information for the cursor position in the status line
+func lspHover() {
+	if !lspAvailable() {
+		return
+	}
+	lspCall("textDocument/hover", cursorParams(), func(result json.RawMessage) {
+		var hover struct {
+			Contents json.RawMessage `json:"contents"`
+		}
+		json.Unmarshal(result, &hover)
+		if s := hoverText(hover.Contents); s != "" {
+			msgNormal(s)
+		} else {
+			msgError("No information.")
+		}
+	})
+}
+
+// Return the first line of text from hover contents, which may be a string,
+// a MarkupContent or MarkedString object, or an array of MarkedStrings
+func hoverText(contents json.RawMessage) string {
+	var s string
+	var markup struct {
+		Value string `json:"value"`
+	}
+	var array []json.RawMessage
+	if json.Unmarshal(contents, &s) != nil {
+		if json.Unmarshal(contents, &markup) == nil {
+			s = markup.Value
+		} else if json.Unmarshal(contents, &array) == nil && len(array) > 0 {
+			return hoverText(array[0])
+		}
+	}
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" &&
+			!strings.HasPrefix(line, "```") {
+			return line
+		}
+	}
+	return ""
+}
+
+// Jump to the definition of the symbol at the cursor
+func lspDefinition() {
+	if !lspAvailable() {
+		return
+	}
+	lspCall("textDocument/definition", cursorParams(),
+		func(result json.RawMessage) {
+			type location struct {
+				URI                  string   `json:"uri"`
+				Range                lspRange `json:"range"`
+				TargetURI            string   `json:"targetUri"`
+				TargetSelectionRange lspRange `json:"targetSelectionRange"`
+			}
+			var locs []location
+			if json.Unmarshal(result, &locs) != nil {
+				var loc location
+				if json.Unmarshal(result, &loc) == nil && loc.URI != "" {
+					locs = append(locs, loc)
+				}
+			}
+			if len(locs) == 0 {
+				msgError("No definition found.")
+				return
+			}
+			loc := locs[0]
+			if loc.TargetURI != "" {
+				loc.URI, loc.Range = loc.TargetURI, loc.TargetSelectionRange
+			}
+			j := jump{mainText, mainText.Index(cursorMark).String()}
+			if visitTarget(target{path: uriPath(loc.URI)}) {
+				jumpStack = append(jumpStack, j)
+				mainText.MarkSet(cursorMark,
+					lspIndex(mainText, loc.Range.Start))
+				editSeparator(mainText)
+			}
+		})
+}
+
+// Request completions for the cursor position and prompt for a choice
+func lspComplete() {
+	if !lspAvailable() || isReadOnly(mainText) {
+		return
+	}
+	lspCall("textDocument/completion", cursorParams(),
+		func(result json.RawMessage) {
+			var list struct {
+				Items []lspCompletionItem `json:"items"`
+			}
+			if json.Unmarshal(result, &list.Items) != nil {
+				json.Unmarshal(result, &list)
+			}
+			switch len(list.Items) {
+			case 0:
+				msgError("No completions.")
+			case 1:
+				complete(list.Items[0])
+			default:
+				if len(list.Items) > 9 {
+					list.Items = list.Items[:9]
+				}
+				completions = list.Items
+				prompt(promptComplete)
+			}
+		})
+}
+
+// Return the prompt string listing completions
+func completePrompt() string {
+	choices := make([]string, len(completions))
+	for i, item := range completions {
+		choices[i] = fmt.Sprintf("%d: %s", i+1, item.Label)
+	}
+	return "Complete (" + strings.Join(choices, ", ") + "): "
+}
+
+// Insert the completion item at the cursor
+func complete(item lspCompletionItem) {
+	editSeparator(mainText)
+	if item.TextEdit != nil {
+		applyEdits(mainText, []lspTextEdit{*item.TextEdit})
+		mainText.MarkSet(cursorMark, fmt.Sprintf("%s+%dc",
+			lspIndex(mainText, item.TextEdit.Range.Start),
+			len([]rune(item.TextEdit.NewText))))
+	} else {
+		s := item.InsertText
+		if s == "" {
+			s = item.Label
+		}
+		// Replace the partial word before the cursor
+		start := cursorMark
+		for mainText.Compare(start, "1.0") > 0 &&
+			wordRegexp.MatchString(mainText.Get(start+"-1c", start)) {
+			start += "-1c"
+		}
+		deleteText(mainText, start, cursorMark)
+		insertText(mainText, cursorMark, s)
+	}
+	editSeparator(mainText)
+}
+
+// Rename the symbol at the cursor throughout the workspace
+func lspRename(name string) {
+	if !lspAvailable() || name == "" {
+		return
+	}
+	params := cursorParams()
+	params["newName"] = name
+	lspCall("textDocument/rename", params, func(result json.RawMessage) {
+		var edit struct {
+			Changes         map[string][]lspTextEdit `json:"changes"`
+			DocumentChanges []struct {
+				TextDocument struct {
+					URI string `json:"uri"`
+				} `json:"textDocument"`
+				Edits []lspTextEdit `json:"edits"`
+			} `json:"documentChanges"`
+		}
+		if err := json.Unmarshal(result, &edit); err != nil {
+			msgError(err.Error())
+			return
+		}
+		if edit.Changes == nil {
+			edit.Changes = make(map[string][]lspTextEdit)
+		}
+		for _, change := range edit.DocumentChanges {
+			uri := change.TextDocument.URI
+			edit.Changes[uri] = append(edit.Changes[uri], change.Edits...)
+		}
+
+		prev := mainText
+		for uri, edits := range edit.Changes {
+			if !visitTarget(target{path: uriPath(uri)}) {
+				return
+			}
+			if isReadOnly(mainText) {
+				msgError("Buffer is read-only.")
+				return
+			}
+			editSeparator(mainText)
+			applyEdits(mainText, edits)
+			editSeparator(mainText)
+		}
+		switchBuffer(prev)
+		lspSync()
+		msgNormal(fmt.Sprintf("Renamed in %d files.", len(edit.Changes)))
+	})
+}
+
+// Apply text edits to the buffer, last first so earlier positions stay valid
+func applyEdits(t *tktext.TkText, edits []lspTextEdit) {
+	sort.SliceStable(edits, func(i, j int) bool {
+		a, b := edits[i].Range.Start, edits[j].Range.Start
+		return a.Line > b.Line || (a.Line == b.Line && a.Character > b.Character)
+	})
+	for _, e := range edits {
+		start := lspIndex(t, e.Range.Start)
+		deleteText(t, start, lspIndex(t, e.Range.End))
+		insertText(t, start, e.NewText)
+	}
+}
+
+// Return the attribute used to mark a diagnostic of the given severity
+func severityColor(severity int) termbox.Attribute {
+	switch severity {
+	case 1:
+		return termbox.ColorRed
+	case 2:
+		return termbox.ColorYellow
+	}
+	return termbox.ColorCyan
+}
+
+// Colour the screen cells covered by diagnostics for the buffer
+func drawDiagnostics(t *tktext.TkText, left, width, height int) {
+	cells := termbox.CellBuffer()
+	for _, d := range diagnostics[lspURIs[t]] {
+		x0, y0 := t.BBox(lspIndex(t, d.Range.Start))
+		x1, y1 := t.BBox(lspIndex(t, d.Range.End))
+		if y0 < 0 || y0 >= height {
+			continue
+		}
+		if y1 != y0 || x1 <= x0 {
+			x1 = x0 + 1
+		}
+		for x := left + x0; x < left+x1 && x < width; x++ {
+			cells[y0*width+x].Fg = severityColor(d.Severity) |
+				termbox.AttrUnderline
+		}
+	}
+}
+
+// Return the first diagnostic on the cursor line of the buffer, if any
+func cursorDiagnostic(t *tktext.TkText) *lspDiagnostic {
+	line := t.Index(cursorMark).Line - 1
+	ds := diagnostics[lspURIs[t]]
+	for i := range ds {
+		if ds[i].Range.Start.Line <= line && ds[i].Range.End.Line >= line {
+			return &ds[i]
+		}
+	}
+	return nil
+}
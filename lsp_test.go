@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jangler/tktext"
+)
+
+// Run a fake language server on the pipes, answering requests with the
+// results in the map and sending a diagnostic notification after initialize
+func fakeServer(t *testing.T, r io.Reader, w io.Writer,
+	results map[string]string, replies chan<- lspMessage) {
+	br := bufio.NewReader(r)
+	for {
+		msg, err := readLSPMessage(br)
+		if err != nil {
+			return
+		}
+		if msg.Method == "" {
+			replies <- msg // Response to our request
+			continue
+		}
+		if msg.ID == nil {
+			continue
+		}
+		writeLSPMessage(w, lspMessage{ID: msg.ID,
+			Result: json.RawMessage(results[msg.Method])})
+		if msg.Method == "initialize" {
+			writeLSPMessage(w, lspMessage{
+				Method: "textDocument/publishDiagnostics",
+				Params: json.RawMessage(`{"uri":"file:///a.go","diagnostics":` +
+					`[{"range":{"start":{"line":1,"character":2},` +
+					`"end":{"line":1,"character":4}},"severity":1,` +
+					`"message":"undefined: x"}]}`),
+			})
+			writeLSPMessage(w, lspMessage{ID: json.RawMessage(`"req"`),
+				Method: "workspace/configuration"})
+		}
+	}
+}
+
+func TestLSPClient(t *testing.T) {
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+	defer clientW.Close()
+	defer serverW.Close()
+
+	results := map[string]string{
+		"initialize":         `{"capabilities":{}}`,
+		"textDocument/hover": `{"contents":{"kind":"plaintext","value":"func f()\nDoc."}}`,
+	}
+	replies := make(chan lspMessage, 1)
+	go fakeServer(t, serverR, serverW, results, replies)
+
+	notes := make(chan json.RawMessage, 1)
+	c := newLSPClient(clientR, clientW, func(method string, p json.RawMessage) {
+		if method == "textDocument/publishDiagnostics" {
+			notes <- p
+		}
+	})
+
+	done := make(chan json.RawMessage, 1)
+	callback := func(result json.RawMessage, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		done <- result
+	}
+	timeout := time.After(5 * time.Second)
+
+	if err := c.call("initialize", map[string]int{}, callback); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-timeout:
+		t.Fatal("no response to initialize")
+	}
+
+	select {
+	case p := <-notes:
+		var diag struct {
+			Diagnostics []lspDiagnostic `json:"diagnostics"`
+		}
+		json.Unmarshal(p, &diag)
+		if len(diag.Diagnostics) != 1 ||
+			diag.Diagnostics[0].Message != "undefined: x" {
+			t.Errorf("diagnostics == %s", p)
+		}
+	case <-timeout:
+		t.Fatal("no diagnostics")
+	}
+
+	select {
+	case msg := <-replies:
+		if string(msg.ID) != `"req"` || string(msg.Result) != "null" {
+			t.Errorf("reply to server request == %#v", msg)
+		}
+	case <-timeout:
+		t.Fatal("no reply to server request")
+	}
+
+	c.call("textDocument/hover", cursorParamsFor("file:///a.go", 0, 0),
+		callback)
+	select {
+	case result := <-done:
+		var hover struct {
+			Contents json.RawMessage `json:"contents"`
+		}
+		json.Unmarshal(result, &hover)
+		if got, want := hoverText(hover.Contents), "func f()"; got != want {
+			t.Errorf("hoverText() == %#v; want %#v", got, want)
+		}
+	case <-timeout:
+		t.Fatal("no response to hover")
+	}
+}
+
+func cursorParamsFor(uri string, line, char int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     lspPosition{line, char},
+	}
+}
+
+func TestHoverText(t *testing.T) {
+	tests := []struct {
+		contents, want string
+	}{
+		{`"plain"`, "plain"},
+		{`{"kind":"markdown","value":"` + "```go\\nvar x int\\n```" + `"}`,
+			"var x int"},
+		{`[{"language":"go","value":"type T"},"doc"]`, "type T"},
+		{`null`, ""},
+	}
+	for _, test := range tests {
+		if got := hoverText(json.RawMessage(test.contents)); got != test.want {
+			t.Errorf("hoverText(%s) == %#v; want %#v", test.contents, got,
+				test.want)
+		}
+	}
+}
+
+func TestLSPPositions(t *testing.T) {
+	text := tktext.New()
+	text.Insert("end", "x\na😀b = é")
+	for _, c := range []struct {
+		index string
+		pos   lspPosition
+	}{
+		{"1.1", lspPosition{0, 1}},
+		{"2.1", lspPosition{1, 1}},
+		{"2.2", lspPosition{1, 3}},
+		{"2.7", lspPosition{1, 8}},
+	} {
+		if got := indexLSP(text, c.index); got != c.pos {
+			t.Errorf("indexLSP(%#v) == %#v; want %#v", c.index, got, c.pos)
+		}
+		if got := lspIndex(text, c.pos); got != c.index {
+			t.Errorf("lspIndex(%#v) == %#v; want %#v", c.pos, got, c.index)
+		}
+	}
+}
+
+func TestLSPClientQueue(t *testing.T) {
+	// Nothing reads from the server's input, so writes to it block
+	clientR, serverW := io.Pipe()
+	_, clientW := io.Pipe()
+	defer serverW.Close()
+	c := newLSPClient(clientR, clientW, func(string, json.RawMessage) {})
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			c.notify("textDocument/didChange", map[string]int{"version": i})
+		}
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("notify blocked on server input")
+	}
+}
+
+// Act as a language server on stdin and stdout when run by TestLSPProcess,
+// logging the method and parameters of each message received. Before
+// answering shutdown, the server sends a notification that nothing reads
+func TestLSPHelperProcess(t *testing.T) {
+	logPath := os.Getenv("ZYGOTE_LSP_LOG")
+	if logPath == "" {
+		return
+	}
+	log, err := os.Create(logPath)
+	if err != nil {
+		os.Exit(2)
+	}
+	r := bufio.NewReader(os.Stdin)
+	for {
+		msg, err := readLSPMessage(r)
+		if err != nil {
+			os.Exit(1)
+		}
+		fmt.Fprintf(log, "%s %s\n", msg.Method, string(msg.Params))
+		switch msg.Method {
+		case "initialize":
+			writeLSPMessage(os.Stdout, lspMessage{ID: msg.ID,
+				Result: json.RawMessage(`{"capabilities":{}}`)})
+		case "shutdown":
+			if os.Getenv("ZYGOTE_LSP_HANG") != "" {
+				continue
+			}
+			writeLSPMessage(os.Stdout, lspMessage{
+				Method: "textDocument/publishDiagnostics",
+				Params: json.RawMessage(`{"uri":"file:///a.go",` +
+					`"diagnostics":[]}`),
+			})
+			writeLSPMessage(os.Stdout, lspMessage{ID: msg.ID,
+				Result: json.RawMessage("null")})
+		case "exit":
+			log.Close()
+			os.Exit(0)
+		}
+	}
+}
+
+func TestLSPProcess(t *testing.T) {
+	withTestBuffer(t)
+	oldP := register['P']
+	defer func() { register['P'] = oldP }()
+	logPath := filepath.Join(t.TempDir(), "log")
+	t.Setenv("ZYGOTE_LSP_LOG", logPath)
+	register['P'] = fmt.Sprintf("exec '%s' -test.run='^TestLSPHelperProcess$'",
+		os.Args[0])
+
+	// Nothing runs the event loop while stopping, as when quitting
+	toggleLSP()
+	runEventsUntil(t, func() bool { return lspReady })
+	select {
+	case <-stopLSP():
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopLSP() did not finish")
+	}
+	p, _ := ioutil.ReadFile(logPath)
+	want := "initialize {\"capabilities\""
+	if lines := strings.Split(string(p), "\n"); len(lines) != 5 ||
+		!strings.HasPrefix(lines[0], want) || lines[1] != "initialized {}" ||
+		lines[2] != "shutdown " || lines[3] != "exit " {
+		t.Errorf("server received:\n%s", p)
+	}
+
+	// A server that ignores shutdown is killed
+	t.Setenv("ZYGOTE_LSP_HANG", "1")
+	toggleLSP()
+	runEventsUntil(t, func() bool { return lspReady })
+	exited := lspExited
+	select {
+	case <-stopLSP():
+	case <-time.After(lspExitTimeout + 5*time.Second):
+		t.Fatal("stopLSP() did not finish")
+	}
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server not killed")
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// Limits that keep runaway macros from hanging the editor
+const (
+	maxMacroSteps = 100000 // Keys and loop iterations per macro
+	maxMacroDepth = 20     // Macros executing macros
+)
+
+// A parsed macro element: a key form, or a control form with the elements in
+// its block
+type macroNode struct {
+	key       string // Key form, if not a control form
+	control   string // repeat, loop, if, or abort
+	arg       string
+	body, alt []macroNode // Alt is the <else> block of an <if>
+}
+
+var (
+	controlRegexp = regexp.MustCompile(
+		`^<(repeat|loop|if|else|done|abort)(?: (.+))?>$`)
+	conditionRegexp = regexp.MustCompile(`^(ok|error|(?s:(.)~(.*)))$`)
+
+	macroDepth   int  // Number of macros being executed
+	macroSteps   int  // Steps taken by the outermost macro
+	macroStopped bool // Whether the running macro was aborted
+	errorCount   int  // Errors reported, for ending loops
+	keyFailed    bool // Whether the last key reported an error
+)
+
+// Split the string into key forms as execString interprets them
+func macroTokens(s string) []string {
+	tokens := make([]string, 0)
+	for len(s) > 0 {
+		if match := formRegexp.FindString(s); match != "" {
+			tokens = append(tokens, match)
+			s = s[len(match):]
+		} else if s[0] == '\\' && len(s) >= 2 {
+			tokens = append(tokens, s[1:2])
+			s = s[2:]
+		} else {
+			tokens = append(tokens, s[0:1])
+			s = s[1:]
+		}
+	}
+	return tokens
+}
+
+// Parse the string into macro elements
+func parseMacro(s string) ([]macroNode, error) {
+	nodes, _, closer, err := parseBlock(macroTokens(s))
+	if err == nil && closer != "" {
+		return nil, fmt.Errorf("<%s> without block.", closer)
+	}
+	return nodes, err
+}
+
+// Parse tokens into macro elements until the end of the tokens or an <else> or
+// <done> form. Returns the elements, the tokens after the block, and the
+// closing form's name, if any
+func parseBlock(tokens []string) ([]macroNode, []string, string, error) {
+	nodes := make([]macroNode, 0)
+	for len(tokens) > 0 {
+		token := tokens[0]
+		tokens = tokens[1:]
+		m := controlRegexp.FindStringSubmatch(token)
+		if m == nil {
+			nodes = append(nodes, macroNode{key: token})
+			continue
+		}
+
+		n := macroNode{control: m[1], arg: m[2]}
+		switch n.control {
+		case "else", "done":
+			return nodes, tokens, n.control, nil
+		case "abort":
+			nodes = append(nodes, n)
+			continue
+		case "repeat":
+			if count, err := strconv.Atoi(n.arg); err != nil || count < 0 {
+				return nil, nil, "", errors.New("Invalid count: " + token)
+			}
+		case "if":
+			c := conditionRegexp.FindStringSubmatch(n.arg)
+			if c == nil {
+				return nil, nil, "", errors.New("Invalid condition: " + token)
+			}
+			if _, err := regexp.Compile(c[3]); err != nil {
+				return nil, nil, "", err
+			}
+		}
+
+		var closer string
+		var err error
+		n.body, tokens, closer, err = parseBlock(tokens)
+		if err == nil && closer == "else" && n.control == "if" {
+			n.alt, tokens, closer, err = parseBlock(tokens)
+		}
+		if err != nil {
+			return nil, nil, "", err
+		}
+		if closer != "done" {
+			return nil, nil, "", fmt.Errorf("<%s> without <done>.", n.control)
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil, "", nil
+}
+
+// Count a step of the running macro, stopping it if it has taken too many.
+// Returns false if the macro should stop
+func macroStep() bool {
+	if macroSteps++; macroSteps > maxMacroSteps && !macroStopped {
+		msgError("Macro stopped after too many steps.")
+		macroStopped = true
+	}
+	return !macroStopped
+}
+
+// Returns true if the condition of an <if> form holds
+func macroCondition(cond string) bool {
+	c := conditionRegexp.FindStringSubmatch(cond)
+	switch c[1] {
+	case "ok":
+		return !keyFailed
+	case "error":
+		return keyFailed
+	}
+	ch := []rune(c[2])[0]
+	return regexp.MustCompile(c[3]).MatchString(getRegister(ch))
+}
+
+// Execute macro elements. Inside a loop, errors is the error count when the
+// iteration began, and execution stops at the first new error; otherwise it
+// is -1
+func runMacro(nodes []macroNode, errors int) {
+	for _, n := range nodes {
+		if errors >= 0 && errorCount != errors || !macroStep() {
+			return
+		}
+		switch n.control {
+		case "":
+			handleKey(n.key)
+		case "abort":
+			msgNormal("Macro aborted.")
+			macroStopped = true
+		case "repeat":
+			count, _ := strconv.Atoi(n.arg)
+			for i := 0; i < count && macroStep(); i++ {
+				runMacro(n.body, errors)
+			}
+		case "loop":
+			// Repeat until a key reports an error
+			for macroStep() {
+				before := errorCount
+				runMacro(n.body, before)
+				if errorCount != before {
+					break
+				}
+			}
+		case "if":
+			if macroCondition(n.arg) {
+				runMacro(n.body, errors)
+			} else {
+				runMacro(n.alt, errors)
+			}
+		}
+	}
+}
+
+// Interpret the string as a series of key and control forms
+func execString(s string) {
+	nodes, err := parseMacro(s)
+	if err != nil {
+		msgError(err.Error())
+		return
+	}
+	if macroDepth == 0 {
+		macroSteps, macroStopped = 0, false
+	} else if macroDepth >= maxMacroDepth {
+		msgError("Macros nested too deeply.")
+		macroStopped = true
+		return
+	}
+	macroDepth++
+	runMacro(nodes, -1)
+	macroDepth--
+	if pendingKeys != "" {
+		resolveKeys(pendingKeys) // No more keys are coming
+	}
+}
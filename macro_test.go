@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+func TestParseMacro(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		ok   bool
+		size int
+	}{
+		{"ab<C-w>", true, 3},
+		{"<repeat 3>a<done>b", true, 2},
+		{"<if a~^x>a<else>b<done>", true, 1},
+		{`\<done>`, true, 6},
+		{"<repeat x>a<done>", false, 0},
+		{"<loop>a", false, 0},
+		{"a<done>", false, 0},
+		{"<loop>a<else>b<done>", false, 0},
+		{"<if maybe>a<done>", false, 0},
+		{"<if a~(>a<done>", false, 0},
+	} {
+		nodes, err := parseMacro(c.s)
+		if (err == nil) != c.ok || len(nodes) != c.size {
+			t.Errorf("parseMacro(%#v) == %d nodes, %v; want %d nodes, ok %v",
+				c.s, len(nodes), err, c.size, c.ok)
+		}
+	}
+}
+
+func TestExecString(t *testing.T) {
+	withTestBuffer(t)
+
+	register['x'] = "yes"
+	for _, c := range []struct {
+		s, want string
+	}{
+		{"<repeat 3>ab<done>", "ababab"},
+		{"<if x~^y>1<else>2<done><if x~^n>3<else>4<done>", "14"},
+		{"<C-f>z<Enter><if error>e<else>o<done><if ok>k<done>", "ek"},
+		{"a<abort>b", "a"},
+		{"<repeat 2><repeat 2>x<done><done>", "xxxx"},
+		{"a.b.c<C-a><loop><C-f>.<Enter><Delete><done>", "abc"},
+		{"<loop>a<done>", ""}, // Stopped by step limit
+	} {
+		mainText.Delete("1.0", "end")
+		execString(c.s)
+		got := mainText.Get("1.0", "end")
+		if c.s == "<loop>a<done>" {
+			if len(got) == 0 || len(got) > maxMacroSteps || !macroStopped {
+				t.Errorf("execString(%#v) not stopped by limit", c.s)
+			}
+			continue
+		}
+		if got != c.want {
+			t.Errorf("execString(%#v) == %#v; want %#v", c.s, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jangler/tktext"
+)
+
+// An error message from build output, with the position it refers to
+type buildError struct {
+	target
+	msg string
+}
+
+var (
+	errorList  []buildError
+	errorIndex = -1
+
+	errorRegexp = regexp.MustCompile(`^\s*(.+?):(\d+)(?::(\d+))?: (.*)$`)
+)
+
+// Parse lines of the form file:line:col: message or file:line: message from
+// build output
+func parseErrors(output string) []buildError {
+	errs := make([]buildError, 0)
+	for _, line := range strings.Split(output, "\n") {
+		if m := errorRegexp.FindStringSubmatch(line); m != nil {
+			var e buildError
+			e.path, e.msg = m[1], m[4]
+			fmt.Sscan(m[2], &e.line)
+			fmt.Sscan(m[3], &e.col)
+			errs = append(errs, e)
+		}
+	}
+	return errs
+}
+
+// Run the make command from register M in the background, and fill the error
+// list from its output
+func runMake() {
+	runBackground(getRegister('M'), func(output string) {
+		errorList = parseErrors(output)
+		errorIndex = -1
+		if len(errorList) == 1 {
+			msgError("1 error. Press F8 to visit it.")
+		} else if len(errorList) > 0 {
+			msgError(fmt.Sprintf("%d errors. Press F8 to visit them.",
+				len(errorList)))
+		}
+	})
+}
+
+// Visit the error d places forward in the error list
+func nextError(d int) {
+	if focusText == promptText {
+		return
+	}
+	if len(errorList) == 0 {
+		msgError("No errors.")
+		return
+	}
+	i := errorIndex + d
+	if i < 0 || i >= len(errorList) {
+		msgError("No more errors.")
+		return
+	}
+	errorIndex = i
+	e := errorList[i]
+	if visitTarget(e.target) {
+		msgError(fmt.Sprintf("(%d of %d) %s", i+1, len(errorList), e.msg))
+	}
+}
+
+// Return the open buffer visiting the file at the given path, if any
+func findBuffer(path string) *tktext.TkText {
+	abs, err := filepath.Abs(expandPath(path))
+	if err != nil {
+		return nil
+	}
+	for _, t := range buffers {
+		name := bufferName(t)
+		if name == "" {
+			continue
+		}
+		if p, err := filepath.Abs(name); err == nil && p == abs {
+			return t
+		}
+	}
+	return nil
+}
+
+// Switch to the buffer visiting the target's file, opening it in a new buffer
+// if necessary, and move the cursor to the target's position. Returns false if
+// the file could not be opened
+func visitTarget(t target) bool {
+	if modeManual {
+		toggleManual()
+	}
+	if buf := findBuffer(t.path); buf != nil {
+		switchBuffer(buf)
+	} else if _, err := os.Stat(expandPath(t.path)); err != nil {
+		msgError(err.Error())
+		return false
+	} else {
+		newBuffer()
+		openFile(t.path)
+	}
+	if t.line > 0 {
+		mainText.MarkSet(cursorMark, targetIndex(t))
+		editSeparator(mainText)
+	}
+	return true
+}
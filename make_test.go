@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestParseErrors(t *testing.T) {
+	output := "# example\n" +
+		"./main.go:12:5: undefined: foo\n" +
+		"    main_test.go:30: got 1; want 2\n" +
+		"ok  \texample\t0.01s\n"
+	want := []buildError{
+		{target{"./main.go", 12, 5}, "undefined: foo"},
+		{target{"main_test.go", 30, 0}, "got 1; want 2"},
+	}
+	got := parseErrors(output)
+	if len(got) != len(want) {
+		t.Fatalf("parseErrors() == %#v; want %#v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("parseErrors()[%d] == %#v; want %#v", i, got[i], want[i])
+		}
+	}
+}
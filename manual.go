@@ -10,27 +10,32 @@ core concepts of Zygote are commands, modes, and registers.
 COMMANDS
 
 Commands perform single actions, and have key bindings that use the Ctrl key
-(abbreviated as C-). Some commands prompt for further input. Most of the
-commands and modes that work in the main buffer also work in the prompt buffer.
-
-  C-_  Undo change to buffer
-  C-a  Start of line
-  C-b  Backward search
-  C-c  Cancel prompt
-  C-e  End of line
-  C-f  Forward search
-  C-h  Delete character
-  C-o  Open file
-  C-p  Put from register
-  C-q  Quit
-  C-r  Redo change to buffer
-  C-s  Save file
-  C-t  Type into register
-  C-u  Delete line
-  C-w  Delete word
-  C-x  Execute from register
-  C-y  Yank into register
-  C-z  Suspend process
+(abbreviated as C-) or function keys. Some commands prompt for further input.
+Most of the commands and modes that work in the main buffer also work in the
+prompt buffer.
+
+$COMMANDS
+
+M-x prompts for a command by name, and runs it. The name can be abbreviated
+by any of its letters in order, as in rdo for redo; Tab completes the best
+match, which is shown after the prompt along with the next best. In macros and
+the configuration file, a command can likewise be run by its name in angle
+brackets, as in <undo>.
+
+Commands that run in the background (make, grep, and run) show their output in
+a read-only buffer. Starting another such command, or quitting, stops the one
+running. Pressing Enter on a line of the form file:line: text in that buffer
+visits the file at that line. Likewise, pressing Enter in the diff buffer shown
+by C-d visits the corresponding line of the diffed buffer, and in the undo
+history shown by F10 restores the state on that line. In the register listing
+shown by F11, Enter puts the register on that line at the cursor and C-t edits
+it.
+
+Changes are never lost to undo. Making a change after undoing starts a new
+branch of the buffer's history; F10 lists the branches. F9 moves through every
+state in the order they were made: -3 goes three states back, +1 one state
+forward, 0 to the original text, and -5m to the state five minutes earlier
+(units are s, m, h, and d).
 
 
 MODES
@@ -39,10 +44,11 @@ Modes toggle flags in the state of the editor, and have key bindings that use
 the Alt or Meta key (abbreviated as M-). Modes are non-exclusive; that is, any
 number of modes can be active at once.
 
-  M-m  Manual
-  M-s  Select
-  M-v  View
-  M-w  Word
+Read-only is kept separately for each buffer. Files that can't be written are
+opened read-only, as are all files given on the command line with -R. Buffers
+showing output are always read-only, and read-only buffers can't be saved.
+
+$MODES
 
 
 REGISTERS
@@ -50,12 +56,40 @@ REGISTERS
 Registers are string variables identified by a single UTF-8 character. Some
 registers identified by capital letters have special meaning to Zygote.
 
+  B  Base name of file (read-only)
   C  Column number of cursor
   D  Last deletion
+  E  Last error message (read-only)
   F  Filename/path
+  H  Directory of file (read-only)
   L  Line number of cursor
+  M  Build command (default "make")
+  N  Number of lines in buffer (read-only)
+  O  Output of last command
+  P  Language server command (default "gopls")
   S  Last search string
   T  Tab width
+  V  Selected text; typing into it replaces the selection
+  W  Word under cursor (read-only)
+  Y  Current date and time (read-only)
+  +  System clipboard
+  1  Deletion before last (through 9, the oldest)
+
+Each deletion moves the earlier ones down a register, from D to 1 and so on,
+so text stored in registers 1 through 9 is soon overwritten by deletions.
+Repeated deletions in the same direction, such as C-w pressed several times,
+are joined into one. After putting a register, M-p replaces the put text with
+the next older deletion, and can be pressed again to keep going back.
+
+M-a does arithmetic on registers that hold integers, such as n+1 or n*2, with
+an empty register counting as zero. Together with C-x, this lets macros keep
+counters: for example, <C-p>n<M-a>n+1<Enter><Down> numbers a line and moves
+to the next.
+
+Registers are saved when Zygote exits and restored when it next starts, except
+for those set by the configuration file and those marked session-only.
+Registers B, E, H, N, V, W, and Y were once ordinary registers; values that
+earlier versions saved in them are discarded.
 
 
 CONFIGURATION
@@ -66,6 +100,83 @@ as a series of key inputs. Non-printable keys and chords can be represented by
 forms such as <Enter>, <C-w>, and <M-w>. To have such a form interpreted as
 literal text, prefix it with a backslash, as in \<C-q>.
 
+The following control forms can also be used, both in the configuration file
+and in registers executed with C-x. Each block ends with <done>, and must be
+complete within one line of the configuration file.
+
+  <repeat N>...<done>
+    Run the block N times.
+
+  <loop>...<done>
+    Run the block until a key in it reports an error, such as a failed search.
+
+  <if ok>...<else>...<done>
+    Run the first block if the last key succeeded, and the second otherwise.
+    <if error> is the opposite, and <else> is optional.
+
+  <if r~pattern>...<else>...<done>
+    Run the first block if register r matches the regular expression pattern,
+    which may not contain >.
+
+  <abort>
+    Stop the macro.
+
+A macro stops after 100000 keys and loop iterations, and C-x may only nest 20
+levels deep, so that mistakes can't hang the editor.
+
+Lines beginning with one of the following words are instead interpreted as
+directives. To type such a line, prefix it with a backslash.
+
+  format <ext> <command>
+    Pipe files with extension <ext> (e.g. .go) through <command> before
+    saving. If the command exits with non-zero status, the file is not saved.
+
+  postsave <ext> <command>
+    Run <command> after saving a file with extension <ext>, with the path of
+    the file as $1. Failures are reported in the status line.
+
+  noundofile <dir>
+    Don't keep undo history for files under <dir> between sessions.
+
+  clipboard <backend>
+    Use <backend> for the + register: osc52 (terminal escape sequences, which
+    work over SSH and in tmux), xclip, wl-copy, or auto, the default, which
+    uses wl-copy or xclip if available and osc52 otherwise.
+
+  bind <key> <action>
+    Bind <key>, written as a key form like <F5> or <C-a>, or as a sequence of
+    them like <C-k>b, to <action>. If <action> is the name of a command, such
+    as undo, the key runs that command; otherwise the key executes <action> as
+    a macro, as with C-x. Command names are shown in parentheses in the lists
+    of commands and modes.
+
+  unbind <key>
+    Remove the binding of <key>. A printable key then types itself again;
+    any other key reports that it is unbound.
+
+  keytimeout <ms>
+    Wait <ms> milliseconds, by default 1000, for the next key of a sequence.
+
+  dateformat <layout>
+    Format register Y using <layout>, written as the time Mon Jan 2 15:04:05
+    2006 would be displayed, e.g. 02/01/2006 or 2006-01-02T15:04:05. The
+    default is 2006-01-02 15:04.
+
+  sessiononly <registers>
+    Don't keep the registers named by the characters of <registers>, as in
+    sessiononly D, between sessions.
+
+After a key that starts a bound sequence, Zygote shows the keys pressed so far
+in the status line and waits for the next one; C-c cancels the sequence. If no
+key comes in time, or none follows in a macro or the configuration file, the
+keys pressed so far run their own binding, if any. Unbound keys that type text
+still type it, as they do when the next key doesn't continue the sequence.
+
+Except under directories named by noundofile, undo history is saved along with
+each file, under $XDG_STATE_HOME/zygote (by default ~/.local/state/zygote), and
+restored when the file is opened again unchanged. Registers are saved in the
+same directory, except for +, O, and any register longer than 64 KiB.
+
 
 CONTRIBUTING
 
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jangler/tktext"
+)
+
+// Registers computed when read, which earlier versions kept like any other
+const computedRegisters = "BEHNVWY"
+
+// Maximum length of a register kept between sessions, in bytes
+const maxSavedRegister = 1 << 16
+
+var (
+	// Not kept between sessions. Command output can be large, and is rarely
+	// wanted in a later session
+	sessionRegisters = map[rune]bool{'+': true, 'O': true}
+	startRegisters   = make(map[rune]string)
+)
+
+// Return the path of the file where registers are kept between sessions
+func registerFilePath() string {
+	return filepath.Join(stateDir(), "registers")
+}
+
+// Read saved registers from the file at path. A missing or invalid file has no
+// registers
+func readRegisterFile(path string) map[string]string {
+	regs := make(map[string]string)
+	if p, err := ioutil.ReadFile(path); err == nil {
+		json.Unmarshal(p, &regs)
+	}
+	return regs
+}
+
+// Restore registers saved by earlier sessions, except those already set by the
+// configuration file, marked session-only, or computed
+func loadRegisters() {
+	for k, v := range readRegisterFile(registerFilePath()) {
+		ch, _ := utf8.DecodeRuneInString(k)
+		if _, ok := register[ch]; !ok && !sessionRegisters[ch] &&
+			!strings.ContainsRune(computedRegisters, ch) {
+			register[ch] = v
+		}
+	}
+	for ch, s := range register {
+		startRegisters[ch] = s
+	}
+}
+
+// Return the saved registers with those changed during this session merged in.
+// Registers this session didn't change keep the values other sessions saved,
+// and registers changed to more than maxSavedRegister bytes aren't saved
+func mergeRegisters(saved map[string]string, start, current map[rune]string,
+	session map[rune]bool) map[string]string {
+	for ch, s := range current {
+		if len(s) > maxSavedRegister {
+			delete(saved, string(ch))
+		} else if s != start[ch] {
+			saved[string(ch)] = s
+		}
+	}
+	for ch := range session {
+		delete(saved, string(ch))
+	}
+	for _, ch := range computedRegisters {
+		delete(saved, string(ch))
+	}
+	for k, v := range saved {
+		if v == "" {
+			delete(saved, k)
+		}
+	}
+	return saved
+}
+
+// Save the registers changed during this session, merging them with those
+// saved by any sessions that ended since this one started
+func saveRegisters() error {
+	path := registerFilePath()
+	regs := mergeRegisters(readRegisterFile(path), startRegisters, register,
+		sessionRegisters)
+	p, err := json.Marshal(regs)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// Replace the file in one step, so that other sessions never read a
+	// partial write
+	f, err := ioutil.TempFile(filepath.Dir(path), "registers")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(p); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Maximum length of a register preview in the register listing
+const previewLength = 60
+
+var (
+	registerText   *tktext.TkText // Not initialized unless we need it
+	registerSource *tktext.TkText // Buffer to put registers into
+)
+
+// Return a one-line preview of s, with control characters escaped
+func previewString(s string) string {
+	q := strconv.Quote(s)
+	q = q[1 : len(q)-1]
+	if utf8.RuneCountInString(q) > previewLength {
+		q = string([]rune(q)[:previewLength-3]) + "..."
+	}
+	return q
+}
+
+// Return the lines of the register listing, one per non-empty register
+func registerLines() []string {
+	names := []rune("BCDEFHLMNOPSTVWY+")
+	for ch := range register {
+		if !strings.ContainsRune(string(names), ch) {
+			names = append(names, ch)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+
+	lines := make([]string, 0)
+	for _, ch := range names {
+		s := register[ch] // The clipboard as last read or written
+		if ch != '+' {
+			s = getRegister(ch)
+		}
+		if s != "" {
+			lines = append(lines, string(ch)+"  "+previewString(s))
+		}
+	}
+	return lines
+}
+
+// List the non-empty registers in a read-only buffer
+func showRegisters() {
+	if focusText != mainText {
+		return
+	}
+	if mainText != registerText {
+		registerSource = mainText
+		if registerText == nil {
+			registerText = newOutputBuffer()
+		} else {
+			switchBuffer(registerText)
+		}
+	}
+	setOutput(registerText, strings.Join(registerLines(), "\n"))
+	registerText.MarkSet(cursorMark, "1.0")
+	msgNormal("Press Enter on a line to put that register, or C-t to edit it.")
+}
+
+// Return the register named on the cursor line of the register listing
+func registerAtCursor() (rune, bool) {
+	line := registerText.Get(cursorMark+" linestart", cursorMark+" lineend")
+	ch, _ := utf8.DecodeRuneInString(line)
+	return ch, line != ""
+}
+
+// Put the register listed on the cursor line at the cursor of the buffer the
+// listing was opened from
+func putRegisterLine() {
+	ch, ok := registerAtCursor()
+	if !ok {
+		return
+	}
+	for _, t := range buffers {
+		if t == registerSource {
+			switchBuffer(t)
+			if isReadOnly(t) {
+				msgError("Buffer is read-only.")
+			} else {
+				insertText(t, cursorMark, getRegister(ch))
+				editSeparator(t)
+			}
+			return
+		}
+	}
+	msgError("Buffer no longer open.")
+}
+
+// Prompt to edit the register listed on the cursor line
+func editRegisterLine() {
+	if ch, ok := registerAtCursor(); ok {
+		regRune = ch
+		prompt(promptWrite)
+		insertText(promptText, "1.0", getRegister(ch))
+	}
+}
+
+// Append s to the contents of the register
+func appendRegister(ch rune, s string) {
+	setRegister(ch, getRegister(ch)+s)
+}
+
+// Apply an arithmetic operation of the form <register><op><integer> to the
+// register, where op is +, -, *, or /. An empty register counts as zero
+func registerArithmetic(expr string) {
+	ch, size := utf8.DecodeRuneInString(expr)
+	rest := strings.TrimSpace(expr[size:])
+	if ch == utf8.RuneError || rest == "" || !strings.ContainsRune("+-*/",
+		rune(rest[0])) {
+		msgError("Invalid arithmetic: " + expr)
+		return
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(rest[1:]))
+	if err != nil {
+		msgError("Invalid arithmetic: " + expr)
+		return
+	}
+	value := 0
+	if s := strings.TrimSpace(getRegister(ch)); s != "" {
+		if value, err = strconv.Atoi(s); err != nil {
+			msgError(fmt.Sprintf("Register %c is not a number.", ch))
+			return
+		}
+	}
+
+	switch rest[0] {
+	case '+':
+		value += n
+	case '-':
+		value -= n
+	case '*':
+		value *= n
+	case '/':
+		if n == 0 {
+			msgError("Division by zero.")
+			return
+		}
+		value /= n
+	}
+	setRegister(ch, strconv.Itoa(value))
+}
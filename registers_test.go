@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeRegisters(t *testing.T) {
+	// Another session saved b and c after this one started
+	saved := map[string]string{"a": "1", "b": "other", "c": "other",
+		"D": "old", "N": "saved by an earlier version"}
+	start := map[rune]string{'a': "1", 'b': "2"}
+	current := map[rune]string{'a': "", 'b': "2", 'd': "new", 'O': "output",
+		'D': strings.Repeat("x", maxSavedRegister+1)}
+	session := map[rune]bool{'O': true}
+
+	got := mergeRegisters(saved, start, current, session)
+	want := map[string]string{"b": "other", "c": "other", "d": "new"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeRegisters() == %#v; want %#v", got, want)
+	}
+}
+
+func TestPreviewString(t *testing.T) {
+	long := strings.Repeat("x", previewLength+10)
+	for _, c := range []struct {
+		s, want string
+	}{
+		{"hello", "hello"},
+		{"a\tb\n\x1b", `a\tb\n\x1b`},
+		{long, long[:previewLength-3] + "..."},
+	} {
+		if got := previewString(c.s); got != c.want {
+			t.Errorf("previewString(%#v) == %#v; want %#v", c.s, got, c.want)
+		}
+	}
+}
+
+func TestRegisterArithmetic(t *testing.T) {
+	delete(register, 'n')
+	for _, c := range []struct {
+		expr, want string
+	}{
+		{"n+1", "1"},
+		{"n + 4", "5"},
+		{"n*3", "15"},
+		{"n/2", "7"},
+		{"n--10", "17"},
+		{"n/0", "17"},
+		{"n%2", "17"},
+	} {
+		registerArithmetic(c.expr)
+		if got := register['n']; got != c.want {
+			t.Errorf("after registerArithmetic(%#v), register['n'] == %#v; "+
+				"want %#v", c.expr, got, c.want)
+		}
+	}
+
+	register['n'] = "abc"
+	appendRegister('n', "def")
+	if got, want := register['n'], "abcdef"; got != want {
+		t.Errorf("after appendRegister(), register['n'] == %#v; want %#v", got,
+			want)
+	}
+}
+
+func TestComputedRegisters(t *testing.T) {
+	oldName := filename
+	defer func() { filename, modeSelect = oldName, false }()
+	withTestBuffer(t)
+	mainText.Insert("end", "one two\nthree")
+	mainText.MarkSet(cursorMark, "1.5")
+	mainText.MarkSet(selMark, "1.7")
+	filename, modeSelect = "/tmp/dir/file.go", true
+	msgError("oops")
+
+	for _, c := range []struct {
+		ch   rune
+		want string
+	}{
+		{'B', "file.go"},
+		{'E', "oops"},
+		{'H', "/tmp/dir"},
+		{'N', "2"},
+		{'V', "wo"},
+		{'W', "two"},
+	} {
+		if got := getRegister(c.ch); got != c.want {
+			t.Errorf("getRegister(%q) == %#v; want %#v", c.ch, got, c.want)
+		}
+	}
+
+	mainText.Insert("end", "\n")
+	if got, want := getRegister('N'), "2"; got != want {
+		t.Errorf("with final newline, getRegister('N') == %#v; want %#v", got,
+			want)
+	}
+
+	setRegister('V', "wice")
+	if got, want := mainText.Get("1.0", "end"), "one twice\nthree\n"; got != want {
+		t.Errorf("after setRegister('V'), text == %#v; want %#v", got, want)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/jangler/tktext"
+)
+
+// Registers holding deleted text, most recent first
+const ringRegisters = "D123456789"
+
+// Mark at the start of the most recently put text
+const putMark = "p"
+
+// Editing actions that affect how the next key behaves
+const (
+	actionNone = iota
+	actionDeleteBackward
+	actionDeleteForward
+	actionPut
+)
+
+var (
+	keyAction  int            // Action of the key being handled
+	prevAction int            // Action of the previous key
+	actionText *tktext.TkText // Buffer of the previous key's action
+	putRing    int            // Position in ringRegisters of the put text
+)
+
+// Record deleted text in the deletion ring. Text deleted by the key right
+// after a deletion in the same direction joins the previous entry; otherwise
+// earlier entries shift down to make room. Empty deletions are ignored
+func ringDelete(s string, action int) {
+	if s == "" {
+		return
+	}
+	if action != actionNone && action == prevAction &&
+		actionText == focusText {
+		if action == actionDeleteBackward {
+			register['D'] = s + register['D']
+		} else {
+			register['D'] += s
+		}
+	} else {
+		for i := len(ringRegisters) - 1; i > 0; i-- {
+			register[rune(ringRegisters[i])] =
+				register[rune(ringRegisters[i-1])]
+		}
+		register['D'] = s
+	}
+	keyAction, actionText = action, focusText
+}
+
+// Put the register at the cursor, remembering where for putPrevious
+func putRegister(ch rune) {
+	if isReadOnly(focusText) {
+		msgError("Buffer is read-only.")
+		return
+	}
+	focusText.MarkSet(putMark, cursorMark)
+	focusText.MarkSetGravity(putMark, tktext.Left)
+	insertText(focusText, cursorMark, getRegister(ch))
+	putRing = strings.IndexRune(ringRegisters, ch)
+	if putRing < 0 {
+		putRing = len(ringRegisters) - 1 // Next is D
+	}
+	keyAction, actionText = actionPut, focusText
+}
+
+// Replace the text just put with the next older entry of the deletion ring
+func putPrevious() {
+	if prevAction != actionPut || actionText != focusText {
+		msgError("Previous key did not put.")
+		return
+	}
+	if isReadOnly(focusText) {
+		msgError("Buffer is read-only.")
+		return
+	}
+	putRing = (putRing + 1) % len(ringRegisters)
+	deleteText(focusText, putMark, cursorMark)
+	insertText(focusText, cursorMark, register[rune(ringRegisters[putRing])])
+	keyAction = actionPut
+	msgNormal("Put register " + ringRegisters[putRing:putRing+1] + ".")
+}
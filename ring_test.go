@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestDeletionRing(t *testing.T) {
+	text := withTestBuffer(t)
+	text.Insert("end", "abcdef")
+	text.MarkSet(cursorMark, "1.3")
+
+	for _, key := range []string{"<Backspace>", "<Backspace>", "<Right>",
+		"<Delete>"} {
+		handleKey(key)
+	}
+	text.MarkSet(cursorMark, "1.0")
+	handleKey("<Backspace>") // Deletes nothing, leaving the ring alone
+	for _, c := range []struct {
+		ch   rune
+		want string
+	}{
+		{'D', "e"},
+		{'1', "bc"},
+	} {
+		if got := register[c.ch]; got != c.want {
+			t.Errorf("register[%q] == %#v; want %#v", c.ch, got, c.want)
+		}
+	}
+
+	for _, key := range []string{"<C-p>", "D", "<M-p>"} {
+		handleKey(key)
+	}
+	if got, want := text.Get("1.0", "end"), "bcadf"; got != want {
+		t.Errorf("after put previous, text == %#v; want %#v", got, want)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jangler/tktext"
+)
+
+var (
+	outputText        *tktext.TkText // Not initialized unless we need it
+	outputCmd         *exec.Cmd      // Running background command, if any
+	outputInterrupted bool
+)
+
+// Return the path of the shell used to run commands
+func shellPath() string {
+	if sh := os.Getenv("SHELL"); sh != "" {
+		return sh
+	}
+	return "/bin/sh"
+}
+
+// Run the command line in the shell with the given input and positional
+// arguments ($1, $2, ...), and return its output. If the command exits with
+// non-zero status, the first line of stderr, if any, is returned as an error.
+// Output to stderr alone, such as a warning, is not an error
+func runCommand(cmdline, input string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	args = append([]string{"-c", cmdline, "zygote"}, args...)
+	cmd := exec.Command(shellPath(), args...)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.New(strings.SplitN(msg, "\n", 2)[0])
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+// Pipe the selection, or the whole buffer if select mode is off, through the
+// command line and replace it with the output
+func pipeCommand(cmdline string) {
+	if focusText != mainText {
+		return
+	}
+
+	start, end := "1.0", "end"
+	if modeSelect {
+		start, end = selMark, cursorMark
+		if mainText.Compare(selMark, cursorMark) > 0 {
+			start, end = end, start
+		}
+	}
+	input := mainText.Get(start, end)
+	if !modeSelect {
+		input = string(bufferBytes(mainText))
+	}
+
+	if isReadOnly(mainText) {
+		msgError("Buffer is read-only.")
+		return
+	}
+	output, err := runCommand(cmdline, input)
+	if err != nil {
+		msgError(err.Error())
+		return
+	}
+	register['O'] = output
+
+	// Don't add a final newline the input didn't have
+	if !strings.HasSuffix(input, "\n") {
+		output = strings.TrimSuffix(output, "\n")
+	}
+
+	if modeSelect {
+		editSeparator(mainText)
+		mainText.MarkSet(selMark, start)
+		mainText.MarkSet(cursorMark, end)
+		deleteText(mainText, selMark, cursorMark)
+		insertText(mainText, selMark, output)
+		editSeparator(mainText)
+	} else {
+		replaceBuffer(mainText, output)
+	}
+}
+
+// Replace the contents of the buffer as a single undoable edit, changing only
+// the lines that differ. The cursor stays on the same logical line, even if
+// lines above it were added or removed
+func replaceBuffer(t *tktext.TkText, s string) {
+	pos := t.Index(cursorMark)
+	a, b := strings.Split(t.Get("1.0", "end"), "\n"), strings.Split(s, "\n")
+	hunks := diffLines(a, b)
+	editSeparator(t)
+	for i := len(hunks) - 1; i >= 0; i-- {
+		h := hunks[i]
+		replaceLines(t, h.oldStart, h.oldLines, b[h.newStart-1:][:h.newLines])
+	}
+	line := mapLine(hunks, pos.Line)
+	if line > len(b) {
+		line = len(b)
+	}
+	t.MarkSet(cursorMark, fmt.Sprintf("%d.%d", line, pos.Char))
+	editSeparator(t)
+}
+
+// Pipe the buffer through the formatter command line. The buffer is left
+// unchanged if it is read-only, or if the formatter fails or changes nothing
+func formatBuffer(t *tktext.TkText, cmdline string) error {
+	if isReadOnly(t) {
+		return errors.New("Buffer is read-only.")
+	}
+	input := string(bufferBytes(t))
+	output, err := runCommand(cmdline, input)
+	if err != nil {
+		return err
+	}
+	if output != input {
+		replaceBuffer(t, output)
+	}
+	return nil
+}
+
+// Run the post-save command lines for the file in the background, reporting
+// the first failure
+func runPostSave(cmdlines []string, path string) {
+	go func() {
+		for _, cmdline := range cmdlines {
+			if _, err := runCommand(cmdline, "", path); err != nil {
+				funcChan <- func() {
+					msgError(fmt.Sprintf("\"%s\": %s", cmdline, err.Error()))
+				}
+				return
+			}
+		}
+	}()
+}
+
+// Insert the output of the command line at the cursor
+func insertCommand(cmdline string) {
+	if isReadOnly(focusText) {
+		msgError("Buffer is read-only.")
+		return
+	}
+	output, err := runCommand(cmdline, "")
+	if err != nil {
+		msgError(err.Error())
+		return
+	}
+	register['O'] = output
+	editSeparator(focusText)
+	insertText(focusText, cursorMark, output)
+	editSeparator(focusText)
+}
+
+// Interval at which output from the background command is appended to the
+// output buffer, so that a command printing many lines doesn't cause a redraw
+// for each one
+const outputInterval = 50 * time.Millisecond
+
+// Output collected by a goroutine and passed to the event loop at most once
+// per outputInterval
+type outputBatch struct {
+	mu        sync.Mutex // Guards pending and scheduled
+	pending   bytes.Buffer
+	scheduled bool
+	flush     func(s string) // Run by the event loop with the pending output
+}
+
+// Add output to the batch, scheduling a flush if none is due
+func (b *outputBatch) add(s string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending.WriteString(s)
+	if !b.scheduled {
+		b.scheduled = true
+		time.AfterFunc(outputInterval, func() { funcChan <- b.drain })
+	}
+}
+
+// Flush the pending output, if any. Must be run by the event loop
+func (b *outputBatch) drain() {
+	b.mu.Lock()
+	s := b.pending.String()
+	b.pending.Reset()
+	b.scheduled = false
+	b.mu.Unlock()
+	if s != "" {
+		b.flush(s)
+	}
+}
+
+// Run the command line in the background, streaming its stdout and stderr
+// into the output buffer. If done is non-nil, it is called with the complete
+// output when the command exits. A command already running is stopped
+func runBackground(cmdline string, done func(string)) {
+	stopCommand()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		msgError(err.Error())
+		return
+	}
+	cmd := exec.Command(shellPath(), "-c", cmdline)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		r.Close()
+		msgError(err.Error())
+		return
+	}
+	outputCmd = cmd
+	outputInterrupted = false
+
+	if outputText == nil {
+		outputText = newOutputBuffer()
+	} else {
+		switchBuffer(outputText)
+	}
+	setOutput(outputText, "")
+	msgNormal(fmt.Sprintf("Running \"%s\".", cmdline))
+
+	go func() {
+		var output bytes.Buffer
+		batch := &outputBatch{flush: func(s string) {
+			if outputCmd == cmd {
+				appendOutput(outputText, s)
+			}
+		}}
+
+		br := bufio.NewReader(r)
+		for {
+			line, err := br.ReadString('\n')
+			if line != "" {
+				output.WriteString(line)
+				batch.add(line)
+			}
+			if err != nil {
+				break
+			}
+		}
+		r.Close()
+		err := cmd.Wait()
+		funcChan <- func() {
+			if outputCmd != cmd {
+				return // Stopped for another command
+			}
+			batch.drain()
+			outputCmd = nil
+			register['O'] = output.String()
+			if err != nil {
+				msgError(fmt.Sprintf("\"%s\": %s", cmdline, err.Error()))
+			} else {
+				msgNormal(fmt.Sprintf("\"%s\" finished.", cmdline))
+			}
+			if done != nil {
+				done(output.String())
+			}
+		}
+	}()
+}
+
+// Replace the contents of a read-only output buffer
+func setOutput(t *tktext.TkText, s string) {
+	t.Delete("1.0", "end")
+	t.MarkSet(cursorMark, "1.0")
+	appendOutput(t, s)
+}
+
+// Append to a read-only output buffer, keeping it free of undo history
+func appendOutput(t *tktext.TkText, s string) {
+	t.Insert("end", s)
+	editReset(t)
+	t.EditSetModified(false)
+}
+
+// Interrupt the background command, or kill it if already interrupted
+func interruptCommand() {
+	sig := syscall.SIGINT
+	if outputInterrupted {
+		sig = syscall.SIGKILL
+	}
+	// Signal the whole process group, as a terminal would
+	if err := syscall.Kill(-outputCmd.Process.Pid, sig); err != nil {
+		msgError(err.Error())
+	} else {
+		outputInterrupted = true
+		msgNormal("Interrupted command.")
+	}
+}
+
+// Terminate the background command, if any, and forget it
+func stopCommand() {
+	if outputCmd != nil {
+		syscall.Kill(-outputCmd.Process.Pid, syscall.SIGTERM)
+		outputCmd = nil
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunCommand(t *testing.T) {
+	got, err := runCommand("sort", "b\na\n")
+	if want := "a\nb\n"; got != want || err != nil {
+		t.Errorf("runCommand(\"sort\") == %#v, %v; want %#v, nil", got, err,
+			want)
+	}
+	if _, err := runCommand("echo oops >&2; exit 1", ""); err == nil ||
+		err.Error() != "oops" {
+		t.Errorf("runCommand(\"echo oops >&2; exit 1\") error == %v; "+
+			"want oops", err)
+	}
+	if got, err := runCommand("echo warning >&2; echo ok", ""); got != "ok\n" ||
+		err != nil {
+		t.Errorf("runCommand() with warning == %#v, %v; want \"ok\\n\", nil",
+			got, err)
+	}
+	if _, err := runCommand("exit 3", ""); err == nil {
+		t.Errorf("runCommand(\"exit 3\") error == nil; want non-nil")
+	}
+}
+
+func TestReplaceBuffer(t *testing.T) {
+	text := withTestBuffer(t)
+	for _, c := range []struct {
+		old, cursor, new, wantCursor string
+	}{
+		{"a\nb\nc\nd\n", "3.1", "x\na\nb\ny\nc\nd\n", "5.1"},
+		{"a\nb\nc\nd", "4.0", "a\nd", "2.0"},
+		{"a\nb\nc", "2.0", "a\nB\nc", "2.0"},
+		{"a\nb\nc", "3.0", "a", "1.0"},
+		{"a\nb", "1.0", "a\nb\nc\n", "1.0"},
+		{"x", "1.1", "", "1.0"},
+	} {
+		text.Delete("1.0", "end")
+		text.Insert("1.0", c.old)
+		text.MarkSet(cursorMark, c.cursor)
+		replaceBuffer(text, c.new)
+		if got := text.Get("1.0", "end"); got != c.new {
+			t.Errorf("replaceBuffer(%#v, %#v): text == %#v", c.old, c.new, got)
+		}
+		if got := text.Index(cursorMark).String(); got != c.wantCursor {
+			t.Errorf("replaceBuffer(%#v, %#v): cursor at %s; want %s", c.old,
+				c.new, got, c.wantCursor)
+		}
+	}
+}
+
+func TestFormatBuffer(t *testing.T) {
+	text := withTestBuffer(t)
+	text.Insert("end", "a\nb\n")
+	editReset(text)
+	if err := formatBuffer(text, "tr a-z A-Z"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := text.Get("1.0", "end"), "A\nB\n"; got != want {
+		t.Errorf("after formatting, text == %#v; want %#v", got, want)
+	}
+
+	// Read-only buffers are left alone, history and all
+	editReset(text)
+	readOnly[text] = true
+	defer delete(readOnly, text)
+	if err := formatBuffer(text, "tr A-Z a-z"); err == nil {
+		t.Errorf("formatBuffer() on read-only buffer == nil; want error")
+	}
+	if got, want := text.Get("1.0", "end"), "A\nB\n"; got != want {
+		t.Errorf("after formatting read-only, text == %#v; want %#v", got,
+			want)
+	}
+	if editSeparator(text); len(historyOf(text).nodes) != 1 {
+		t.Errorf("formatting read-only buffer changed its history")
+	}
+}
+
+// Run functions sent to the event loop until cond is true
+func runEventsUntil(t *testing.T, cond func() bool) {
+	deadline := time.After(5 * time.Second)
+	for !cond() {
+		select {
+		case f := <-funcChan:
+			f()
+		case <-deadline:
+			t.Fatal("timed out waiting for background command")
+		}
+	}
+}
+
+func TestRunBackground(t *testing.T) {
+	withTestBuffer(t)
+	defer func() { outputText = nil }()
+
+	var got string
+	finished := false
+	runBackground("echo a; echo b", func(output string) {
+		got, finished = output, true
+	})
+	runEventsUntil(t, func() bool { return finished })
+	if want := "a\nb\n"; got != want || register['O'] != want ||
+		outputText.Get("1.0", "end") != want {
+		t.Errorf("output == %#v, register O == %#v, buffer == %#v; want %#v",
+			got, register['O'], outputText.Get("1.0", "end"), want)
+	}
+	if outputCmd != nil {
+		t.Errorf("outputCmd != nil after command finished")
+	}
+
+	// Starting a command stops the one running
+	runBackground("sleep 10", nil)
+	pid := outputCmd.Process.Pid
+	finished = false
+	runBackground("echo c", func(string) { finished = true })
+	runEventsUntil(t, func() bool {
+		return finished && syscall.Kill(pid, 0) != nil
+	})
+	if got, want := outputText.Get("1.0", "end"), "c\n"; got != want {
+		t.Errorf("buffer == %#v; want %#v", got, want)
+	}
+}
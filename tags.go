@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jangler/tktext"
+)
+
+// A ctags entry
+type tag struct {
+	name, path, address string
+}
+
+// A position to return to after jumping to a tag
+type jump struct {
+	text  *tktext.TkText
+	index string
+}
+
+var (
+	tagMatches []tag
+	tagStart   int // Index in tagMatches of the first match listed
+	jumpStack  []jump
+)
+
+// Return the path of the nearest tags file in the working directory or one of
+// its parents
+func findTagsFile() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		path := filepath.Join(dir, "tags")
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("No tags file.")
+		}
+		dir = parent
+	}
+}
+
+// Return the entries for the name in the tags file at path, with file paths
+// made relative to the working directory where possible
+func readTags(path, name string) ([]tag, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tags := make([]tag, 0)
+	dir := filepath.Dir(path)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if t, ok := parseTag(scanner.Text()); ok && t.name == name {
+			if !filepath.IsAbs(t.path) {
+				t.path = filepath.Join(dir, t.path)
+			}
+			if wd, err := os.Getwd(); err == nil {
+				if rel, err := filepath.Rel(wd, t.path); err == nil {
+					t.path = rel
+				}
+			}
+			tags = append(tags, t)
+		}
+	}
+	return tags, scanner.Err()
+}
+
+// Parse a line of an Exuberant/Universal ctags file, of the form
+// name<Tab>file<Tab>address;"<Tab>fields
+func parseTag(line string) (tag, bool) {
+	if strings.HasPrefix(line, "!_TAG_") {
+		return tag{}, false
+	}
+	fields := strings.SplitN(line, "\t", 3)
+	if len(fields) < 3 {
+		return tag{}, false
+	}
+	address := fields[2]
+	if i := strings.LastIndex(address, ";\""); i >= 0 {
+		address = address[:i]
+	}
+	return tag{fields[0], fields[1], address}, true
+}
+
+// Return the 1-based line number that the tag address refers to in the text,
+// or 0 if it can't be found. Addresses are line numbers or search patterns
+// like /^func main() {$/
+func tagLine(text, address string) int {
+	if n, err := strconv.Atoi(address); err == nil {
+		return n
+	}
+	if len(address) < 2 {
+		return 0
+	}
+	pattern := address[1 : len(address)-1]
+	start := strings.HasPrefix(pattern, "^")
+	end := strings.HasSuffix(pattern, "$") && !strings.HasSuffix(pattern, "\\$")
+	pattern = strings.TrimPrefix(pattern, "^")
+	if end {
+		pattern = pattern[:len(pattern)-1]
+	}
+	r := strings.NewReplacer(`\/`, `/`, `\?`, `?`, `\\`, `\`, `\$`, `$`)
+	pattern = r.Replace(pattern)
+
+	for i, line := range strings.Split(text, "\n") {
+		if (start && end && line == pattern) ||
+			(start && !end && strings.HasPrefix(line, pattern)) ||
+			(!start && end && strings.HasSuffix(line, pattern)) ||
+			(!start && !end && strings.Contains(line, pattern)) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Return the word under the cursor, in the wordRegexp sense
+func wordAtCursor() string {
+	pos := focusText.Index(cursorMark)
+	line := focusText.Get(cursorMark+" linestart", cursorMark+" lineend")
+	start := pos.Char
+	if start > len(line) {
+		start = len(line)
+	}
+	end := start
+	for start > 0 && wordRegexp.Match([]byte{line[start-1]}) {
+		start--
+	}
+	for end < len(line) && wordRegexp.Match([]byte{line[end]}) {
+		end++
+	}
+	return line[start:end]
+}
+
+// Jump to the definition of the word under the cursor, prompting if there is
+// more than one
+func jumpToTag() {
+	if focusText != mainText {
+		return
+	}
+	name := wordAtCursor()
+	if name == "" {
+		msgError("No identifier under cursor.")
+		return
+	}
+	path, err := findTagsFile()
+	if err != nil {
+		msgError(err.Error())
+		return
+	}
+	tags, err := readTags(path, name)
+	if err != nil {
+		msgError(err.Error())
+		return
+	}
+
+	switch len(tags) {
+	case 0:
+		msgError(fmt.Sprintf("No tag for \"%s\".", name))
+	case 1:
+		visitTag(tags[0])
+	default:
+		tagMatches, tagStart = tags, 0
+		prompt(promptTag)
+	}
+}
+
+// Return the prompt listing the ambiguous tag matches that fit on a line of
+// the given width, at most nine, and the number listed
+func tagPrompt(width int) (string, int) {
+	s, n := "", 0
+	choices := make([]string, 0, 9)
+	for i := tagStart; i < len(tagMatches) && len(choices) < 9; i++ {
+		t := tagMatches[i]
+		choice := fmt.Sprintf("%d: %s", len(choices)+1, t.path)
+		if line := tagLine("", t.address); line > 0 {
+			choice += fmt.Sprintf(":%d", line)
+		}
+		choices = append(choices, choice)
+		p := "Jump to tag (" + strings.Join(choices, ", ")
+		if len(choices) < len(tagMatches) {
+			p += fmt.Sprintf("; %d-%d of %d, Space for more", tagStart+1, i+1,
+				len(tagMatches))
+		}
+		p += "): "
+		if n > 0 && len(p) > width {
+			break
+		}
+		s, n = p, len(choices)
+	}
+	return s, n
+}
+
+// Show the next page of ambiguous tag matches, wrapping around to the first
+func nextTagPage(width int) {
+	_, n := tagPrompt(width)
+	if tagStart += n; tagStart >= len(tagMatches) {
+		tagStart = 0
+	}
+}
+
+// Jump to the tag, remembering the current position
+func visitTag(t tag) {
+	j := jump{mainText, mainText.Index(cursorMark).String()}
+	if !visitTarget(target{path: t.path}) {
+		return
+	}
+	jumpStack = append(jumpStack, j)
+	if line := tagLine(mainText.Get("1.0", "end"), t.address); line > 0 {
+		mainText.MarkSet(cursorMark, fmt.Sprintf("%d.0", line))
+		editSeparator(mainText)
+	} else {
+		msgError(fmt.Sprintf("Tag \"%s\" not found in file.", t.name))
+	}
+}
+
+// Return to the position before the last tag jump
+func jumpBack() {
+	if focusText != mainText {
+		return
+	}
+	if len(jumpStack) == 0 {
+		msgError("No jump to return from.")
+		return
+	}
+	j := jumpStack[len(jumpStack)-1]
+	jumpStack = jumpStack[:len(jumpStack)-1]
+	for _, t := range buffers {
+		if t == j.text {
+			if modeManual {
+				toggleManual()
+			}
+			switchBuffer(t)
+			mainText.MarkSet(cursorMark, j.index)
+			return
+		}
+	}
+	msgError("Buffer no longer open.")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestParseTag(t *testing.T) {
+	line := "main\tzygote.go\t/^func main() {$/;\"\tf"
+	want := tag{"main", "zygote.go", "/^func main() {$/"}
+	if got, ok := parseTag(line); got != want || !ok {
+		t.Errorf("parseTag(%#v) == %#v, %v; want %#v, true", line, got, ok,
+			want)
+	}
+	if _, ok := parseTag("!_TAG_FILE_FORMAT\t2\t/extended format/"); ok {
+		t.Errorf("parseTag() accepted header line")
+	}
+}
+
+func TestTagLine(t *testing.T) {
+	text := "package main\n\nfunc mainly() {}\n\nfunc main() {\n}"
+	tests := []struct {
+		address string
+		want    int
+	}{
+		{"3", 3},
+		{"/^func main() {$/", 5},
+		{"/^func main/", 3},
+		{"/^package/", 1},
+		{"?^}$?", 6},
+		{"/^func missing/", 0},
+	}
+	for _, test := range tests {
+		if got := tagLine(text, test.address); got != test.want {
+			t.Errorf("tagLine(%#v) == %d; want %d", test.address, got,
+				test.want)
+		}
+	}
+}
+
+func TestTagPrompt(t *testing.T) {
+	defer func() { tagMatches, tagStart = nil, 0 }()
+	tagMatches = make([]tag, 12)
+	for i := range tagMatches {
+		tagMatches[i] = tag{"f", "f.go", strconv.Itoa(i + 1)}
+	}
+
+	for _, c := range []struct {
+		width int
+		want  string
+	}{
+		{40, "Jump to tag (1: f.go:1; 1-1 of 12, Space for more): "},
+		{70, "Jump to tag (1: f.go:1, 2: f.go:2; 1-2 of 12, Space for more): "},
+	} {
+		if got, _ := tagPrompt(c.width); got != c.want {
+			t.Errorf("tagPrompt(%d) == %#v; want %#v", c.width, got, c.want)
+		}
+	}
+
+	nextTagPage(200)
+	want := "Jump to tag (1: f.go:10, 2: f.go:11, 3: f.go:12; 10-12 of 12, " +
+		"Space for more): "
+	if got, n := tagPrompt(200); got != want || n != 3 {
+		t.Errorf("on second page, tagPrompt() == %#v, %d; want %#v, 3", got, n,
+			want)
+	}
+	if nextTagPage(200); tagStart != 0 {
+		t.Errorf("after last page, tagStart == %d; want 0", tagStart)
+	}
+}
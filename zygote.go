@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/user"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -35,11 +36,33 @@ const (
 	promptWriteWhich
 	promptExecute
 	promptYank
+	promptPipe
+	promptInsertOutput
+	promptRun
+	promptGrep
+	promptGrepDir
+	promptTag
+	promptComplete
+	promptRename
+	promptDiff
+	promptTravel
+	promptAppend
+	promptAppendWhich
+	promptAppendYank
+	promptArithmetic
+	promptCommand
 )
 
 var (
 	// Command-line flags/args
 	filename, rcPath string
+	targets          []target
+	filterMode       bool
+	viewOnly         bool
+
+	// Buffer read from stdin, if any, and the process exit status
+	stdinText  *tktext.TkText
+	exitStatus int
 
 	// Status line
 	statusFg   termbox.Attribute
@@ -53,6 +76,17 @@ var (
 
 	manualText *tktext.TkText // Not initialized unless we need it
 
+	// Buffers that reject insertions and deletions
+	readOnly = make(map[*tktext.TkText]bool)
+
+	// Buffers showing output, which are replaced rather than edited and are
+	// always read-only
+	outputBuffers = make(map[*tktext.TkText]bool)
+
+	// Open main buffers, in order, and the filenames of those not in focus
+	buffers     = []*tktext.TkText{mainText}
+	bufferFiles = make(map[*tktext.TkText]string)
+
 	cursorCol = map[*tktext.TkText]int{
 		mainText:   0,
 		manualText: 0,
@@ -61,18 +95,25 @@ var (
 	register = make(map[rune]string)
 	regRune  rune
 
-	// Event channels
+	lastError  string               // For register E
+	dateFormat = "2006-01-02 15:04" // For register Y, as a Go time layout
+
+	// Event channels. Goroutines other than the event loop send functions on
+	// funcChan to modify editor state
 	eventChan = make(chan termbox.Event)
+	funcChan  = make(chan func())
 	quitChan  = make(chan bool, 1)
 
 	// Modes
-	modeManual, modeSelect, modeView, modeWord bool
+	modeGit, modeLSP, modeManual, modeSelect, modeView, modeWord bool
 
 	// Regexps
 	wordRegexp  = regexp.MustCompile(`\w`)
 	spaceRegexp = regexp.MustCompile(`\s`)
 	formRegexp  = regexp.MustCompile(`^<.+?>`)
 
+	targetRegexp = regexp.MustCompile(`^(.*?):(\d+)(?::(\d+))?:?$`)
+
 	tabStop = 8
 )
 
@@ -119,6 +160,9 @@ func msgNormal(s string) {
 
 // Set the status message to the given string, with error attribute
 func msgError(s string) {
+	lastError = s
+	keyFailed = true
+	errorCount++
 	statusMsg = s
 	statusFg = termbox.ColorRed
 }
@@ -126,9 +170,18 @@ func msgError(s string) {
 // Returns a status line string describing active modes
 func modeString() string {
 	modes := make([]string, 0)
+	if modeGit {
+		modes = append(modes, "git (M-g)")
+	}
+	if modeLSP {
+		modes = append(modes, "LSP (M-l)")
+	}
 	if modeManual {
 		modes = append(modes, "manual (M-m)")
 	}
+	if isReadOnly(mainText) {
+		modes = append(modes, "read-only (M-r)")
+	}
 	if modeSelect {
 		modes = append(modes, "select (M-s)")
 	}
@@ -152,12 +205,18 @@ func draw() {
 	drawText := mainText
 	if modeManual {
 		drawText = manualText
-		drawText.EditUndo() // No changing the manual!
 	}
-	drawText.SetSize(width, height-1)
+	left := 0 // Width of the gutter
+	if modeGit && drawText == mainText {
+		left = gutterWidth
+	}
+	drawText.SetSize(width-left, height-1)
 	if !modeView {
 		drawText.See(cursorMark)
 	}
+	if left > 0 {
+		drawGutter(drawText, height-1)
+	}
 	curX, curY := drawText.BBox(cursorMark)
 	selX, selY := curX, curY
 	if modeSelect {
@@ -168,32 +227,38 @@ func draw() {
 	}
 	for i, line := range drawText.GetScreenLines() {
 		if !modeSelect {
-			drawStringDefault(0, i, line)
+			drawStringDefault(left, i, line)
 		} else if i > selY {
 			if i < curY {
-				drawString(0, i, line, selFg, selBg)
+				drawString(left, i, line, selFg, selBg)
 			} else if i == curY {
-				drawString(0, i, line[:curX], selFg, selBg)
-				drawStringDefault(curX, i, line[curX:])
+				drawString(left, i, line[:curX], selFg, selBg)
+				drawStringDefault(left+curX, i, line[curX:])
 			} else {
-				drawStringDefault(0, i, line)
+				drawStringDefault(left, i, line)
 			}
 		} else if i == selY {
 			if i < curY {
-				drawStringDefault(0, i, line[:selX])
-				drawString(selX, i, line[selX:], selFg, selBg)
+				drawStringDefault(left, i, line[:selX])
+				drawString(left+selX, i, line[selX:], selFg, selBg)
 			} else if i == curY {
-				drawStringDefault(0, i, line[:selX])
-				drawString(selX, i, line[selX:curX], selFg, selBg)
-				drawStringDefault(curX, i, line[curX:])
+				drawStringDefault(left, i, line[:selX])
+				drawString(left+selX, i, line[selX:curX], selFg, selBg)
+				drawStringDefault(left+curX, i, line[curX:])
 			}
 		} else {
-			drawStringDefault(0, i, line)
+			drawStringDefault(left, i, line)
 		}
 	}
+	if modeLSP {
+		drawDiagnostics(drawText, left, width, height-1)
+	}
+	if drawText == diffText {
+		drawDiffColors(drawText, left, width, height-1)
+	}
 	curX, curY = drawText.BBox(cursorMark)
 	if curY >= 0 && curY < height-1 {
-		termbox.SetCursor(curX, curY)
+		termbox.SetCursor(left+curX, curY)
 	} else {
 		termbox.HideCursor()
 	}
@@ -223,6 +288,36 @@ func draw() {
 			s = "Execute from register: "
 		case promptYank:
 			s = "Yank into register: "
+		case promptAppend:
+			s = "Append: "
+		case promptAppendWhich:
+			s = "Append to register: "
+		case promptAppendYank:
+			s = "Yank onto end of register: "
+		case promptArithmetic:
+			s = "Arithmetic (e.g. n+1, n-2, n*3, n/4): "
+		case promptPipe:
+			s = "Pipe through command: "
+		case promptInsertOutput:
+			s = "Insert output of command: "
+		case promptRun:
+			s = "Run command: "
+		case promptGrep:
+			s = "Search files for (/regexp/ or text): "
+		case promptGrepDir:
+			s = "Search files in directory: "
+		case promptTag:
+			s, _ = tagPrompt(width)
+		case promptComplete:
+			s = completePrompt()
+		case promptRename:
+			s = "Rename to: "
+		case promptDiff:
+			s = "Diff against file or buffer: "
+		case promptTravel:
+			s = "Go to state (N, -N, +N, or -Nm like -5m): "
+		case promptCommand:
+			s = "Command (Tab completes): "
 		}
 
 		drawStringDefault(0, height-1, s)
@@ -240,11 +335,33 @@ func draw() {
 		} else {
 			drawStringDefault(x, height-1, s)
 		}
+		if promptMode == promptCommand {
+			drawString(x+len(s)+2, height-1, commandMatchString(s),
+				termbox.ColorBlue, termbox.ColorDefault)
+		}
 		pos := promptText.Index(cursorMark)
 		termbox.SetCursor(x+pos.Char, height-1)
+	} else if statusMsg == "" && pendingKeys != "" {
+		drawStringDefault(0, height-1,
+			"Keys: "+keyName(pendingKeys)+" (C-c cancels)")
 	} else if statusMsg == "" {
-		// Draw modes, cursor row,col numbers, and scroll percentage
-		drawStringDefault(0, height-1, modeString())
+		// Draw modes (or diagnostic), cursor row,col numbers, and scroll
+		// percentage
+		if d := cursorDiagnostic(drawText); d != nil {
+			drawString(0, height-1, d.Message, severityColor(d.Severity),
+				termbox.ColorDefault)
+		} else {
+			s := modeString()
+			if modeGit {
+				if branch := gitBranch(); branch != "" {
+					if s != "" {
+						s += "  "
+					}
+					s += "Branch: " + branch
+				}
+			}
+			drawStringDefault(0, height-1, s)
+		}
 		pos := indexPos(drawText, cursorMark, tabStop)
 		drawStringDefault(width-17, height-1, pos)
 		drawStringDefault(width-4, height-1, scrollPercent(drawText.YView()))
@@ -260,6 +377,11 @@ func draw() {
 	}
 }
 
+// Returns true if the buffer may not be edited
+func isReadOnly(t *tktext.TkText) bool {
+	return readOnly[t] || outputBuffers[t]
+}
+
 // Reset the focus when leaving a prompt
 func unprompt() {
 	if modeManual {
@@ -272,14 +394,54 @@ func unprompt() {
 func getRegister(ch rune) string {
 	var s string
 	switch ch {
+	case 'B':
+		if filename != "" {
+			s = filepath.Base(filename)
+		}
 	case 'C':
 		s = fmt.Sprintf("%d", focusText.Index(cursorMark).Char)
+	case 'E':
+		s = lastError
 	case 'F':
 		s = filename
+	case 'H':
+		if filename != "" {
+			s = filepath.Dir(filename)
+		}
 	case 'L':
 		s = fmt.Sprintf("%d", focusText.Index(cursorMark).Line)
+	case 'M':
+		if s = register['M']; s == "" {
+			s = "make"
+		}
+	case 'N':
+		n := focusText.Index("end").Line
+		if n > 1 && focusText.Get("end-1c", "end") == "\n" {
+			n-- // The final newline doesn't start another line
+		}
+		s = fmt.Sprintf("%d", n)
+	case 'P':
+		if s = register['P']; s == "" {
+			s = "gopls"
+		}
 	case 'T':
 		s = fmt.Sprintf("%d", tabStop)
+	case 'V':
+		if modeSelect && focusText.Compare(cursorMark, selMark) != 0 {
+			s = getSelText()
+		}
+	case 'W':
+		s = wordAtCursor()
+	case 'Y':
+		s = time.Now().Format(dateFormat)
+	case '+':
+		var err error
+		if s, err = readClipboard(); err != nil {
+			msgError(err.Error())
+			s = register['+']
+		} else {
+			register['+'] = s // For the register listing
+		}
 	default:
 		s = register[ch]
 	}
@@ -316,10 +478,35 @@ func setRegister(ch rune, s string) {
 				n = 1
 			}
 			tabStop = int(n)
-			mainText.SetTabStop(tabStop)
+			for _, t := range buffers {
+				t.SetTabStop(tabStop)
+			}
 		} else {
 			msgError(err.Error())
 		}
+	case 'V':
+		if !modeSelect || focusText.Compare(cursorMark, selMark) == 0 {
+			msgError("No selection.")
+		} else if isReadOnly(focusText) {
+			msgError("Buffer is read-only.")
+		} else {
+			// Replace the selection, keeping the replacement selected
+			start, end := selMark, cursorMark
+			if focusText.Compare(selMark, cursorMark) > 0 {
+				start, end = cursorMark, selMark
+			}
+			index := focusText.Index(start).String()
+			deleteText(focusText, start, end)
+			insertText(focusText, index, s)
+			focusText.MarkSet(selMark, index)
+		}
+	case 'B', 'E', 'H', 'N', 'W', 'Y':
+		msgError(fmt.Sprintf("Register %c is read-only.", ch))
+	case '+':
+		register[ch] = s
+		if err := writeClipboard(s); err != nil {
+			msgError(err.Error())
+		}
 	default:
 		register[ch] = s
 	}
@@ -359,45 +546,98 @@ func search(forward bool) {
 	}
 }
 
+// Handle a key form, which may start, continue, or complete a key sequence.
+// Returns true if the event loop should stop
 func handleKey(s string) bool {
+	seq := pendingKeys + s
+	if keyPrefixes[seq] > 0 {
+		if macroDepth > 0 {
+			pendingKeys = seq // Resolved when the macro ends, if not before
+		} else {
+			waitForKey(seq)
+		}
+		return false
+	}
+	if pending := pendingKeys; pending != "" {
+		clearPendingKeys()
+		if _, ok := bindings[seq]; !ok {
+			switch {
+			case s == "<C-c>":
+				msgNormal("Cancelled.")
+			case isTextKeys(pending):
+				// The keys were text after all
+				return resolveKeys(pending) || handleKey(s)
+			default:
+				msgError("Unbound key: " + seq)
+			}
+			return false
+		}
+	}
+	return runKey(seq)
+}
+
+// Run the binding of a complete key sequence. Returns true if the event loop
+// should stop
+func runKey(s string) bool {
 	stop := false
 	sep := false     // Whether an undo separator should be inserted
 	resetCol := true // Whether cursorCol should be reset
+	prevAction, keyAction = keyAction, actionNone
+	keyFailed = false
+
+	name, ok := bindings[s]
+	if !ok && len(s) > 2 && isKeyForm(s) {
+		// Forms like <undo> run commands by name
+		name = s[1 : len(s)-1]
+		_, ok = findCommand(name)
+	}
+	if !ok {
+		if len(s) > 1 {
+			msgError("Unbound key: " + s)
+		} else {
+			// Loop only iterates once
+			for _, ch := range s {
+				stop = typeRune(ch)
+			}
+		}
+	} else if _, ok := findCommand(name); !ok {
+		execString(name) // Bound to a macro
+	}
 
-	switch s {
-	case "<Down>":
+	switch name {
+	case "line-down":
 		if modeView && focusText != promptText {
 			focusText.YViewScroll(1)
 		} else {
 			changeLine(1)
 			sep, resetCol = true, false
 		}
-	case "<Left>":
+	case "char-left":
 		moveCursor("-1c")
 		sep = true
-	case "<Right>":
+	case "char-right":
 		moveCursor("+1c")
 		sep = true
-	case "<Up>":
+	case "line-up":
 		if modeView && focusText != promptText {
 			focusText.YViewScroll(-1)
 		} else {
 			changeLine(-1)
 			sep, resetCol = true, false
 		}
-	case "<Backspace>", "<C-8>", "<C-h>":
+	case "delete-char":
 		del("-1c")
-	case "<Delete>":
+	case "delete-forward":
 		del("+1c")
-	case "<End>", "<C-e>":
+	case "line-end":
 		focusText.MarkSet(cursorMark, cursorMark+" lineend")
 		sep = true
-	case "<Enter>", "<C-m>":
+	case "newline":
 		typeRune('\n')
-	case "<Home>", "<C-a>":
+	case "line-start":
 		focusText.MarkSet(cursorMark, cursorMark+" linestart")
 		sep = true
-	case "<PgDn>":
+	case "page-down":
 		_, height := termbox.Size()
 		if modeView && focusText != promptText {
 			focusText.YViewScroll(height - 1)
@@ -405,7 +645,7 @@ func handleKey(s string) bool {
 			changeLine(height - 1)
 			sep, resetCol = true, false
 		}
-	case "<PgUp>":
+	case "page-up":
 		_, height := termbox.Size()
 		if modeView && focusText != promptText {
 			focusText.YViewScroll(-(height - 1))
@@ -413,103 +653,153 @@ func handleKey(s string) bool {
 			changeLine(-(height - 1))
 			sep, resetCol = true, false
 		}
-	case "<Space>":
+	case "space":
 		typeRune(' ')
-	case "<Tab>", "<C-i>":
-		typeRune('\t')
-	case "<C-b>":
+	case "tab":
+		if focusText == promptText && promptMode == promptCommand {
+			completeCommand()
+		} else {
+			typeRune('\t')
+		}
+	case "search-backward":
 		if focusText == promptText && promptMode == promptSearchBackward {
 			unprompt()
 			search(false)
 		} else {
 			prompt(promptSearchBackward)
 		}
-	case "<C-c>":
+	case "cancel":
 		cancel()
-	case "<C-f>":
+	case "command":
+		prompt(promptCommand)
+	case "diff":
+		if focusText == mainText && mainText != diffText {
+			prompt(promptDiff)
+			insertText(promptText, "1.0", filename)
+		}
+	case "search-forward":
 		if focusText == promptText && promptMode == promptSearchForward {
 			unprompt()
 			search(true)
 		} else {
 			prompt(promptSearchForward)
 		}
-	case "<C-o>":
+	case "make":
+		runMake()
+	case "grep":
+		prompt(promptGrep)
+	case "next-buffer":
+		nextBuffer()
+	case "insert-output":
+		prompt(promptInsertOutput)
+	case "open":
 		if mainText.EditGetModified() {
 			prompt(promptOpenYN)
 		} else {
 			prompt(promptOpen)
 		}
-	case "<C-p>":
+	case "put":
 		prompt(promptPut)
-	case "<C-s>":
+	case "save":
 		saveFile(true)
-	case "<C-q>":
-		if mainText.EditGetModified() {
+	case "quit":
+		if buffersModified() {
 			prompt(promptQuitYN)
 		} else {
 			stop = true
 			quitChan <- true
 		}
-	case "<C-r>":
+	case "redo":
 		redo()
-	case "<C-_>", "<C-/>":
+	case "pipe":
+		prompt(promptPipe)
+	case "jump-to-tag":
+		jumpToTag()
+	case "jump-back":
+		jumpBack()
+	case "undo":
 		undo()
-	case "<C-t>":
-		prompt(promptWriteWhich)
-	case "<C-u>":
+	case "type-register":
+		if focusText == registerText {
+			editRegisterLine()
+		} else {
+			prompt(promptWriteWhich)
+		}
+	case "delete-line":
 		del(" linestart")
-	case "<C-w>":
+	case "run":
+		prompt(promptRun)
+	case "delete-word":
 		del("-1w")
-	case "<C-x>":
+	case "execute":
 		prompt(promptExecute)
-	case "<C-y>":
+	case "yank":
 		prompt(promptYank)
-	case "<C-z>":
+	case "suspend":
 		stop = true
 		suspend()
-	case "<M-m>":
+	case "lsp-hover":
+		lspHover()
+	case "lsp-rename":
+		if lspAvailable() {
+			prompt(promptRename)
+			insertText(promptText, "1.0", wordAtCursor())
+		}
+	case "lsp-complete":
+		lspComplete()
+	case "travel":
+		if focusText == mainText {
+			prompt(promptTravel)
+		}
+	case "history":
+		showHistory()
+	case "registers":
+		showRegisters()
+	case "lsp-definition":
+		lspDefinition()
+	case "revert-hunk":
+		revertHunk()
+	case "previous-hunk":
+		nextHunk(-1)
+	case "next-hunk":
+		nextHunk(1)
+	case "previous-error":
+		nextError(-1)
+	case "next-error":
+		nextError(1)
+	case "arithmetic":
+		prompt(promptArithmetic)
+	case "git-mode":
+		toggleGit()
+	case "lsp-mode":
+		toggleLSP()
+	case "manual-mode":
 		toggleManual()
-	case "<M-s>":
+	case "put-previous":
+		putPrevious()
+	case "read-only-mode":
+		toggleReadOnly()
+	case "select-mode":
 		toggleSelect()
-	case "<M-v>":
+	case "append-register":
+		prompt(promptAppendWhich)
+	case "view-mode":
 		modeView = !modeView
-	case "<M-w>":
+	case "word-mode":
 		modeWord = !modeWord
-	default:
-		if len(s) > 1 {
-			msgError("Unbound key: " + s)
-		} else {
-			// Loop only iterates once
-			for _, ch := range s {
-				stop = typeRune(ch)
-			}
-		}
+	case "append-yank":
+		prompt(promptAppendYank)
 	}
 
 	if resetCol {
 		cursorCol[focusText] = 0
 	}
-	if sep && focusText == mainText {
-		mainText.EditSeparator()
+	if sep {
+		editSeparator(focusText)
 	}
 	return stop
 }
 
-func execString(s string) {
-	for len(s) > 0 {
-		if match := formRegexp.FindString(s); match != "" {
-			handleKey(match)
-			s = s[len(match):]
-		} else if s[0] == '\\' && len(s) >= 2 {
-			handleKey(s[1:2])
-			s = s[2:]
-		} else {
-			handleKey(s[0:1])
-			s = s[1:]
-		}
-	}
-}
-
 // Enter the rune into the focused buffer. Entering line feed into a prompt
 // confirms it. Returns true if the event loop should stop
 func typeRune(ch rune) bool {
@@ -523,26 +813,46 @@ func typeRune(ch rune) bool {
 				unprompt()
 				saveFile(true)
 			case promptQuitYN:
+				exitStatus = 1 // Signal abandoned changes to e.g. git
 				quitChan <- true
 				return true
 			}
 		} else if ch == 'n' {
 			unprompt()
 		}
+	} else if focusText == promptText && promptMode == promptTag {
+		width, _ := termbox.Size()
+		_, count := tagPrompt(width)
+		if n := int(ch - '1'); n >= 0 && n < count {
+			unprompt()
+			visitTag(tagMatches[tagStart+n])
+		} else if ch == ' ' {
+			nextTagPage(width)
+		}
+	} else if focusText == promptText && promptMode == promptComplete {
+		if n := int(ch - '1'); n >= 0 && n < len(completions) {
+			unprompt()
+			complete(completions[n])
+		}
 	} else if focusText == promptText && (promptMode == promptPut ||
 		promptMode == promptWriteWhich || promptMode == promptYank ||
-		promptMode == promptExecute) {
+		promptMode == promptExecute || promptMode == promptAppendWhich ||
+		promptMode == promptAppendYank) {
 		regRune = ch
 		unprompt()
 		switch promptMode {
 		case promptPut:
-			focusText.Insert(cursorMark, getRegister(ch))
+			putRegister(ch)
 		case promptWriteWhich:
 			prompt(promptWrite)
 		case promptExecute:
 			execString(getRegister(ch))
 		case promptYank:
 			setRegister(ch, getSelText())
+		case promptAppendWhich:
+			prompt(promptAppend)
+		case promptAppendYank:
+			appendRegister(ch, getSelText())
 		}
 	} else if ch == '\n' && focusText == promptText {
 		unprompt()
@@ -560,7 +870,45 @@ func typeRune(ch rune) bool {
 			search(true)
 		case promptWrite:
 			setRegister(regRune, promptText.Get("1.0", "end"))
+			if mainText == registerText {
+				showRegisters()
+			}
+		case promptAppend:
+			appendRegister(regRune, promptText.Get("1.0", "end"))
+		case promptArithmetic:
+			registerArithmetic(promptText.Get("1.0", "end"))
+		case promptCommand:
+			runNamedCommand(promptText.Get("1.0", "end"))
+		case promptPipe:
+			pipeCommand(promptText.Get("1.0", "end"))
+		case promptInsertOutput:
+			insertCommand(promptText.Get("1.0", "end"))
+		case promptRun:
+			runBackground(promptText.Get("1.0", "end"), nil)
+		case promptGrep:
+			grepPattern = promptText.Get("1.0", "end")
+			prompt(promptGrepDir)
+			insertText(promptText, "1.0", grepDir)
+		case promptGrepDir:
+			runGrep(promptText.Get("1.0", "end"))
+		case promptRename:
+			lspRename(promptText.Get("1.0", "end"))
+		case promptDiff:
+			diffBuffer(promptText.Get("1.0", "end"))
+		case promptTravel:
+			travelHistory(promptText.Get("1.0", "end"))
 		}
+	} else if ch == '\n' &&
+		(focusText == outputText || focusText == grepText) {
+		visitResult()
+	} else if ch == '\n' && focusText == diffText {
+		visitDiffLine()
+	} else if ch == '\n' && focusText == undoText {
+		visitHistoryLine()
+	} else if ch == '\n' && focusText == registerText {
+		putRegisterLine()
+	} else if isReadOnly(focusText) {
+		msgError("Buffer is read-only.")
 	} else {
 		s := string(ch)
 
@@ -578,11 +926,11 @@ func typeRune(ch rune) bool {
 
 			// Delete empty lines
 			if i == len(prevLine) {
-				focusText.Delete(cursorMark+" linestart", cursorMark)
+				deleteText(focusText, cursorMark+" linestart", cursorMark)
 			}
 		}
 
-		focusText.Insert(cursorMark, s)
+		insertText(focusText, cursorMark, s)
 	}
 
 	return false
@@ -606,12 +954,20 @@ func changeLine(d int) {
 func openFile(path string) {
 	path = expandPath(path)
 	if p, err := ioutil.ReadFile(path); err == nil {
+		if outputBuffers[mainText] {
+			newBuffer() // Leave output buffers alone
+		}
 		mainText.Delete("1.0", "end")
 		mainText.Insert("1.0", string(p))
 		mainText.MarkSet(cursorMark, "1.0")
-		mainText.EditReset()
+		editReset(mainText)
 		mainText.EditSetModified(false)
+		undoErr := readUndoFile(mainText, path, p)
+		readOnly[mainText] = !writable(path)
 		msgNormal(fmt.Sprintf("Opened \"%s\".", path))
+		if undoErr != nil {
+			msgError("Opened, but not undo history: " + undoErr.Error())
+		}
 		filename = path
 	} else {
 		msgError(err.Error())
@@ -621,6 +977,7 @@ func openFile(path string) {
 // Enter the given prompt mode
 func prompt(mode int) {
 	promptText.Delete("1.0", "end")
+	editReset(promptText)
 	promptMode = mode
 	focusText = promptText
 }
@@ -631,7 +988,9 @@ func saveFile(overwrite bool) {
 		return
 	}
 
-	if filename == "" {
+	if isReadOnly(mainText) {
+		msgError("Buffer is read-only.")
+	} else if filename == "" {
 		prompt(promptSave)
 	} else {
 		_, err := os.Stat(filename)
@@ -640,22 +999,43 @@ func saveFile(overwrite bool) {
 			return
 		}
 
-		p := []byte(mainText.Get("1.0", "end"))
-
-		// Ensure file has final newline
-		if len(p) > 0 && p[len(p)-1] != '\n' {
-			p = append(p, '\n')
+		ext := filepath.Ext(filename)
+		if cmdline := formatters[ext]; cmdline != "" {
+			if err := formatBuffer(mainText, cmdline); err != nil {
+				msgError("Not saved. Formatter: " + err.Error())
+				return
+			}
 		}
 
+		p := bufferBytes(mainText)
 		if err := ioutil.WriteFile(filename, p, 0644); err == nil {
-			mainText.EditSetModified(false)
+			editSaved(mainText)
+			gitForget()
 			msgNormal(fmt.Sprintf("Saved \"%s\".", filename))
+			if err := writeUndoFile(mainText, filename, p); err != nil {
+				msgError("Saved, but not undo history: " + err.Error())
+			}
+			if cmdlines := postSaveHooks[ext]; len(cmdlines) > 0 {
+				runPostSave(cmdlines, filename)
+			}
 		} else {
 			msgError(err.Error())
 		}
 	}
 }
 
+// Return the contents of the buffer as they should be written out
+func bufferBytes(t *tktext.TkText) []byte {
+	p := []byte(t.Get("1.0", "end"))
+
+	// Ensure file has final newline
+	if len(p) > 0 && p[len(p)-1] != '\n' {
+		p = append(p, '\n')
+	}
+
+	return p
+}
+
 // Suspend the process (like ^Z in bash)
 func suspend() {
 	if proc, err := os.FindProcess(os.Getpid()); err == nil {
@@ -670,54 +1050,145 @@ func suspend() {
 		termbox.Init()
 		termbox.SetInputMode(termbox.InputAlt)
 		draw()
-		getEvent()
+		go getEvent()
 	} else {
 		msgError(err.Error())
 	}
 }
 
-// Cancel out of a prompt
+// Cancel out of a prompt, or interrupt the background command
 func cancel() {
 	if focusText == promptText {
 		unprompt()
 		msgNormal("Cancelled.")
+	} else if grepStop != nil {
+		stopGrep()
+	} else if outputCmd != nil {
+		interruptCommand()
 	}
 }
 
 // Initialize command-line flags and args
 func initFlags() {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [<options>] [<file>]", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [<options>] [[+<line>] <file>]...",
+			os.Args[0])
+		fmt.Fprint(os.Stderr, "\n\nA file may be given as <file>:<line> or "+
+			"<file>:<line>:<col>, or as - to read\nfrom standard input.")
 		fmt.Fprint(os.Stderr, "\n\nOptions:\n")
 		flag.PrintDefaults()
 	}
 	flag.StringVar(&rcPath, "rc", "~/.zygoterc", "path to rc file")
+	flag.BoolVar(&filterMode, "filter", false,
+		"write buffer read from stdin (or first buffer) to stdout on quit")
+	flag.BoolVar(&viewOnly, "R", false, "open files read-only")
 
 	flag.Parse()
 
-	if flag.NArg() == 1 {
-		filename = flag.Arg(0)
-	} else if flag.NArg() > 1 {
+	line := 0
+	for _, arg := range flag.Args() {
+		if strings.HasPrefix(arg, "+") {
+			if n, err := strconv.Atoi(arg[1:]); err == nil && n > 0 {
+				line = n
+				continue
+			}
+		}
+		t := parseTarget(arg)
+		if line > 0 {
+			t.line, t.col = line, 0
+			line = 0
+		}
+		targets = append(targets, t)
+	}
+	if line > 0 {
 		flag.Usage()
 		os.Exit(1)
 	}
+
+	// Read piped input even if no "-" argument was given
+	if fi, err := os.Stdin.Stat(); err == nil &&
+		fi.Mode()&os.ModeCharDevice == 0 {
+		for _, t := range targets {
+			if t.path == "-" {
+				return
+			}
+		}
+		targets = append([]target{{path: "-"}}, targets...)
+	}
 }
 
-// Undo change to main buffer
-func undo() {
-	if focusText == mainText {
-		if !mainText.EditUndo(cursorMark) {
-			msgError("Nothing to undo.")
+// A file to open at startup, and the 1-based cursor position to open it at.
+// Zero line or column numbers are unspecified.
+type target struct {
+	path      string
+	line, col int
+}
+
+// Parse a command-line file argument of the form <file>, <file>:<line>, or
+// <file>:<line>:<col>, as emitted by compilers and grep. An argument naming an
+// existing file is always taken literally.
+func parseTarget(arg string) target {
+	if _, err := os.Stat(arg); err == nil {
+		return target{path: arg}
+	}
+	if m := targetRegexp.FindStringSubmatch(arg); m != nil && m[1] != "" {
+		t := target{path: m[1]}
+		t.line, _ = strconv.Atoi(m[2])
+		t.col, _ = strconv.Atoi(m[3])
+		return t
+	}
+	return target{path: arg}
+}
+
+// Open the given target in the main buffer. A path of "-" reads from stdin.
+// Keyboard input is unaffected, since termbox reads keys from /dev/tty
+func openTarget(t target) {
+	if t.path == "-" {
+		stdinText = mainText
+		if p, err := ioutil.ReadAll(os.Stdin); err == nil {
+			mainText.Insert("1.0", string(p))
+			mainText.MarkSet(cursorMark, "1.0")
+			editReset(mainText)
+			mainText.EditSetModified(false)
+		} else {
+			msgError(err.Error())
 		}
+	} else {
+		filename = t.path // Keep the name even if the file doesn't exist yet
+		openFile(t.path)
+	}
+	if viewOnly {
+		readOnly[mainText] = true
+	}
+	if t.line > 0 {
+		mainText.MarkSet(cursorMark, targetIndex(t))
+	}
+}
+
+// Return the buffer index of the target's position
+func targetIndex(t target) string {
+	col := t.col - 1
+	if col < 0 {
+		col = 0
 	}
+	return fmt.Sprintf("%d.%d", t.line, col)
 }
 
-// Redo change to main buffer
+// Undo change to focused buffer
+func undo() {
+	if isReadOnly(focusText) {
+		msgError("Buffer is read-only.")
+	} else if !stepHistory(focusText, true) {
+		msgError("Nothing to undo.")
+	}
+}
+
+// Redo change to focused buffer
 func redo() {
-	if focusText == mainText {
-		if !mainText.EditRedo(cursorMark) {
-			msgError("Nothing to redo.")
-		}
+	if isReadOnly(focusText) {
+		msgError("Buffer is read-only.")
+	} else if !stepHistory(focusText, false) {
+		msgError("Nothing to redo.")
 	}
 }
 
@@ -727,16 +1198,39 @@ func toggleManual() {
 		modeManual = !modeManual
 		if manualText == nil {
 			manualText = tktext.New()
-			manualText.Insert("end", manualString)
+			manualText.Insert("end", formatManual())
 			manualText.EditReset()
 			manualText.MarkSet(cursorMark, "1.0")
 			manualText.MarkSet(selMark, cursorMark)
 			manualText.MarkSetGravity(selMark, tktext.Left)
+			readOnly[manualText] = true
 		}
 		unprompt()
 	}
 }
 
+// Toggle whether the main buffer may be edited
+func toggleReadOnly() {
+	if focusText != mainText {
+		return
+	}
+	if outputBuffers[mainText] {
+		msgError("Output buffers are always read-only.")
+		return
+	}
+	readOnly[mainText] = !readOnly[mainText]
+}
+
+// Returns true if the file at path may be written
+func writable(path string) bool {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
 // Toggle select mode
 func toggleSelect() {
 	modeSelect = !modeSelect
@@ -747,6 +1241,82 @@ func toggleSelect() {
 	promptText.MarkSet(selMark, cursorMark)
 }
 
+// Initialize a main buffer with the default settings
+func initBuffer(t *tktext.TkText) {
+	t.SetWrap(tktext.Char)
+	t.SetTabStop(tabStop)
+	t.MarkSet(cursorMark, "end")
+	t.MarkSet(selMark, cursorMark)
+	t.MarkSetGravity(selMark, tktext.Left)
+}
+
+// Create a new main buffer and switch to it
+func newBuffer() *tktext.TkText {
+	t := tktext.New()
+	initBuffer(t)
+	buffers = append(buffers, t)
+	switchBuffer(t)
+	return t
+}
+
+// Create a new read-only main buffer for output and switch to it
+func newOutputBuffer() *tktext.TkText {
+	t := newBuffer()
+	outputBuffers[t] = true
+	return t
+}
+
+// Make the given buffer the main buffer
+func switchBuffer(t *tktext.TkText) {
+	bufferFiles[mainText] = filename
+	mainText = t
+	filename = bufferFiles[t]
+	delete(bufferFiles, t)
+	mainText.MarkSet(selMark, cursorMark)
+	unprompt()
+}
+
+// Return the filename of the open buffer
+func bufferName(t *tktext.TkText) string {
+	if t == mainText {
+		return filename
+	}
+	return bufferFiles[t]
+}
+
+// Switch to the next open buffer
+func nextBuffer() {
+	if focusText != mainText {
+		return
+	}
+	if len(buffers) < 2 {
+		msgError("No other buffers.")
+		return
+	}
+	for i, t := range buffers {
+		if t == mainText {
+			switchBuffer(buffers[(i+1)%len(buffers)])
+			break
+		}
+	}
+	if filename == "" {
+		msgNormal("Switched to unnamed buffer.")
+	} else {
+		msgNormal(fmt.Sprintf("Switched to \"%s\".", filename))
+	}
+}
+
+// Returns true if any open buffer has unsaved changes. In filter mode, the
+// filtered buffer is "saved" to stdout on quit and so doesn't count
+func buffersModified() bool {
+	for _, t := range buffers {
+		if t.EditGetModified() && !(filterMode && t == filterText()) {
+			return true
+		}
+	}
+	return false
+}
+
 // This function is nasty.
 func moveCursor(modifier string) {
 	if modeWord || modifier == "-1w" {
@@ -814,17 +1384,65 @@ func moveCursor(modifier string) {
 // Delete text. If there is a selection, delete the selection. Otherwise,
 // select text from the cursor to the modifier, then delete it.
 func del(modifier string) {
+	if isReadOnly(focusText) {
+		msgError("Buffer is read-only.")
+		return
+	}
 	if !modeSelect || focusText.Compare(selMark, cursorMark) == 0 {
 		focusText.MarkSet(selMark, cursorMark)
 		moveCursor(modifier)
 	}
+	action := actionNone // Deleted selections are never joined
+	if !modeSelect {
+		action = actionDeleteBackward
+	}
 	if focusText.Compare(selMark, cursorMark) < 0 {
-		register['D'] = focusText.Get(selMark, cursorMark)
-		focusText.Delete(selMark, cursorMark)
+		if !modeSelect {
+			action = actionDeleteForward
+		}
+		ringDelete(focusText.Get(selMark, cursorMark), action)
+		deleteText(focusText, selMark, cursorMark)
 	} else {
-		register['D'] = focusText.Get(cursorMark, selMark)
-		focusText.Delete(cursorMark, selMark)
+		ringDelete(focusText.Get(cursorMark, selMark), action)
+		deleteText(focusText, cursorMark, selMark)
+	}
+}
+
+// Return the buffer to write to stdout in filter mode
+func filterText() *tktext.TkText {
+	if stdinText != nil {
+		return stdinText
+	}
+	return buffers[0]
+}
+
+// Write the filtered buffer to stdout
+func writeFilter() error {
+	_, err := os.Stdout.Write(bufferBytes(filterText()))
+	return err
+}
+
+// Return the process exit status. Besides abandoned changes, quitting with
+// unsaved changes to a file named on the command line is a failure, so that
+// programs using Zygote as their editor (e.g. git commit) can tell that the
+// edit was cancelled. Read-only buffers don't count
+func quitStatus() int {
+	if exitStatus != 0 {
+		return exitStatus
+	}
+	named := make(map[string]bool)
+	for _, t := range targets {
+		if t.path != "-" {
+			named[expandPath(t.path)] = true
+		}
 	}
+	for _, t := range buffers {
+		if name := bufferName(t); name != "" && named[expandPath(name)] &&
+			!isReadOnly(t) && t.EditGetModified() {
+			return 1
+		}
+	}
+	return 0
 }
 
 // Take appropriate action for the given termbox event
@@ -838,6 +1456,9 @@ func handleEvent(event termbox.Event) {
 	case termbox.EventKey:
 		stop = handleKey(keyString(event))
 		if !stop {
+			if modeLSP {
+				lspSync()
+			}
 			draw()
 		}
 	case termbox.EventResize:
@@ -845,7 +1466,7 @@ func handleEvent(event termbox.Event) {
 	}
 
 	if !stop {
-		getEvent()
+		go getEvent()
 	}
 }
 
@@ -859,7 +1480,10 @@ func handleEvents() {
 	for {
 		select {
 		case event := <-eventChan:
-			go handleEvent(event)
+			handleEvent(event)
+		case f := <-funcChan:
+			f()
+			draw()
 		case <-quitChan:
 			return
 		}
@@ -877,17 +1501,6 @@ func expandPath(path string) string {
 	return path
 }
 
-func readConfig(path string) {
-	path = expandPath(path)
-	if p, err := ioutil.ReadFile(path); err == nil {
-		for _, line := range strings.Split(string(p), "\n") {
-			execString(line)
-		}
-	} else {
-		msgError(err.Error())
-	}
-}
-
 // Entry point
 func main() {
 	initFlags()
@@ -896,24 +1509,43 @@ func main() {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
-	defer termbox.Close()
+	defer termbox.Close() // In case of panic; os.Exit skips deferred calls
 	termbox.SetInputMode(termbox.InputAlt)
 
-	mainText.SetWrap(tktext.Char)
-	mainText.SetTabStop(tabStop)
-	mainText.MarkSet(cursorMark, "end")
-	mainText.MarkSet(selMark, cursorMark)
-	mainText.MarkSetGravity(selMark, tktext.Left)
+	initBuffer(mainText)
 	promptText.MarkSet(cursorMark, "end")
 	promptText.MarkSet(selMark, cursorMark)
 	promptText.MarkSetGravity(selMark, tktext.Left)
 	msgNormal("Zygote, alpha version. Press M-m to view manual.")
 	readConfig(rcPath)
-	if filename != "" {
-		openFile(filename)
+	loadRegisters()
+	for i, t := range targets {
+		if i > 0 {
+			newBuffer()
+		}
+		openTarget(t)
+	}
+	if len(buffers) > 1 {
+		switchBuffer(buffers[0])
 	}
 	draw()
 
 	go getEvent()
 	handleEvents()
+
+	stopCommand()
+	if lsp != nil {
+		<-stopLSP()
+	}
+	termbox.Close()
+	if err := saveRegisters(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+	}
+	if filterMode {
+		if err := writeFilter(); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			exitStatus = 1
+		}
+	}
+	os.Exit(quitStatus())
 }
@@ -1,11 +1,29 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/jangler/tktext"
 )
 
+// Replace the open buffers with an empty one for the rest of the test, and
+// return it. The previous buffers are restored on cleanup
+func withTestBuffer(t *testing.T) *tktext.TkText {
+	oldMain, oldFocus, oldBuffers := mainText, focusText, buffers
+	oldName := filename
+	text := tktext.New()
+	text.MarkSet(cursorMark, "end")
+	mainText, focusText, buffers = text, text, []*tktext.TkText{text}
+	t.Cleanup(func() {
+		mainText, focusText, buffers = oldMain, oldFocus, oldBuffers
+		filename = oldName
+	})
+	return text
+}
+
 func TestIndexPos(t *testing.T) {
 	text := tktext.New()
 	text.SetSize(10, 5)
@@ -39,3 +57,133 @@ func TestScrollPercent(t *testing.T) {
 		t.Errorf("scrollPercent(0.5, 1) == %#v; want %#v", got, want)
 	}
 }
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want target
+	}{
+		{"main.go", target{"main.go", 0, 0}},
+		{"main.go:42", target{"main.go", 42, 0}},
+		{"main.go:42:7", target{"main.go", 42, 7}},
+		{"main.go:42:7:", target{"main.go", 42, 7}},
+		{"dir/a:b.go:3", target{"dir/a:b.go", 3, 0}},
+		{":42", target{":42", 0, 0}},
+	}
+	for _, test := range tests {
+		if got := parseTarget(test.arg); got != test.want {
+			t.Errorf("parseTarget(%#v) == %#v; want %#v", test.arg, got,
+				test.want)
+		}
+	}
+}
+
+func TestPromptUndo(t *testing.T) {
+	defer func() { focusText = mainText }()
+	prompt(promptSearchForward)
+	promptText.MarkSet(cursorMark, "end")
+	for _, key := range []string{"a", "b", "<Left>", "<End>", "c"} {
+		handleKey(key)
+	}
+	for _, want := range []string{"ab", ""} {
+		undo()
+		if got := promptText.Get("1.0", "end"); got != want {
+			t.Errorf("after undo, prompt == %#v; want %#v", got, want)
+		}
+	}
+
+	// Read-only buffers reject edits
+	withTestBuffer(t)
+	readOnly[focusText] = true
+	defer delete(readOnly, focusText)
+	typeRune('x')
+	del("-1c")
+	if got := focusText.Get("1.0", "end"); got != "" {
+		t.Errorf("read-only buffer == %#v; want %#v", got, "")
+	}
+}
+
+func TestOutputBuffers(t *testing.T) {
+	withTestBuffer(t)
+	out := newOutputBuffer()
+	defer delete(outputBuffers, out)
+	setOutput(out, "output")
+
+	toggleReadOnly()
+	if !isReadOnly(out) {
+		t.Errorf("toggleReadOnly() made output buffer writable")
+	}
+
+	// Opening a file leaves the output buffer alone
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "a.txt")
+	if err := ioutil.WriteFile(path, []byte("file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	openFile(path)
+	if mainText == out || out.Get("1.0", "end") != "output" {
+		t.Errorf("openFile() replaced output buffer")
+	}
+
+	// Read-only buffers aren't saved
+	mainText.Insert("end", " changed")
+	readOnly[mainText] = true
+	defer delete(readOnly, mainText)
+	saveFile(true)
+	if p, _ := ioutil.ReadFile(path); string(p) != "file" {
+		t.Errorf("saveFile() wrote read-only buffer: %#v", string(p))
+	}
+	if want := "Buffer is read-only."; statusMsg != want {
+		t.Errorf("statusMsg == %#v; want %#v", statusMsg, want)
+	}
+}
+
+func TestWritable(t *testing.T) {
+	f, err := ioutil.TempFile("", "zygote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if !writable(f.Name()) {
+		t.Errorf("writable(%#v) == false; want true", f.Name())
+	}
+	if writable(f.Name() + ".missing") {
+		t.Errorf("writable(%#v) == true; want false", f.Name()+".missing")
+	}
+}
+
+func TestQuitStatus(t *testing.T) {
+	text := withTestBuffer(t)
+	oldTargets := targets
+	defer func() {
+		targets, exitStatus = oldTargets, 0
+		delete(readOnly, text)
+	}()
+
+	for _, c := range []struct {
+		targets            []target
+		status             int
+		modified, readOnly bool
+		want               int
+	}{
+		{nil, 0, false, false, 0},
+		{[]target{{path: "COMMIT_EDITMSG"}}, 0, false, false, 0},
+		{[]target{{path: "COMMIT_EDITMSG"}}, 0, true, false, 1},
+		{[]target{{path: "COMMIT_EDITMSG"}}, 1, false, false, 1},
+		{[]target{{path: "COMMIT_EDITMSG"}}, 0, true, true, 0}, // -R
+		{[]target{{path: "-"}}, 0, true, false, 0},
+	} {
+		targets, exitStatus = c.targets, c.status
+		filename = ""
+		if len(c.targets) > 0 && c.targets[0].path != "-" {
+			filename = c.targets[0].path
+		}
+		text.EditSetModified(c.modified)
+		readOnly[text] = c.readOnly
+		if got := quitStatus(); got != c.want {
+			t.Errorf("quitStatus() with %+v == %d; want %d", c, got, c.want)
+		}
+	}
+}